@@ -0,0 +1,311 @@
+package el
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// builtin is a function available in every expression. It is called with
+// its evaluated arguments, once their count has been checked.
+type builtin struct {
+	minArgs int
+	maxArgs int          // -1 when variadic
+	typ     reflect.Type // type of the result, nil when it depends on the arguments
+	call    func(args []*Value) (*Value, error)
+}
+
+var (
+	stringType   = reflect.TypeOf("")
+	boolType     = reflect.TypeOf(false)
+	listType     = reflect.TypeOf([]interface{}{})
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// builtins are resolved before the methods of the target, so that
+// len(Comments) or upper(Title) work whatever the target is.
+var builtins = map[string]builtin{
+	"len":        {1, 1, intType, builtinLen},
+	"upper":      {1, 1, stringType, stringFunc(strings.ToUpper)},
+	"lower":      {1, 1, stringType, stringFunc(strings.ToLower)},
+	"trim":       {1, 2, stringType, builtinTrim},
+	"contains":   {2, 2, boolType, builtinContains},
+	"startsWith": {2, 2, boolType, stringPredicate(strings.HasPrefix)},
+	"endsWith":   {2, 2, boolType, stringPredicate(strings.HasSuffix)},
+	"split":      {2, 2, reflect.TypeOf([]string{}), builtinSplit},
+	"join":       {2, 2, stringType, builtinJoin},
+	"keys":       {1, 1, listType, builtinKeys},
+	"values":     {1, 1, listType, builtinValues},
+	"min":        {1, -1, nil, extremum(-1)},
+	"max":        {1, -1, nil, extremum(1)},
+	"abs":        {1, 1, nil, builtinAbs},
+	"round":      {1, 2, nil, builtinRound},
+	"now":        {0, 0, timeType, builtinNow},
+	"duration":   {1, 1, durationType, builtinDuration},
+}
+
+// arity describes the number of arguments b takes.
+func (b builtin) arity() string {
+	switch {
+	case b.maxArgs < 0:
+		return fmt.Sprintf("at least %d", b.minArgs)
+	case b.minArgs == b.maxArgs:
+		return fmt.Sprintf("%d", b.minArgs)
+	default:
+		return fmt.Sprintf("%d to %d", b.minArgs, b.maxArgs)
+	}
+}
+
+// stringArg returns the i-th argument, which must be a string.
+func stringArg(args []*Value, i int) (string, error) {
+	if !args[i].IsString() {
+		return "", fmt.Errorf("argument %d must be a string (not %s)", i+1, kindOf(args[i]))
+	}
+	return args[i].String(), nil
+}
+
+// items returns the elements of a slice or array argument.
+func items(arg *Value, i int) ([]*Value, error) {
+	rv := arg.getResolvedValue()
+	switch rv.Kind() {
+	case reflect.Array, reflect.Slice:
+	default:
+		return nil, fmt.Errorf("argument %d must be a slice or an array (not %s)", i+1, kindOf(arg))
+	}
+	elems := make([]*Value, 0, rv.Len())
+	for j := 0; j < rv.Len(); j++ {
+		elem := rv.Index(j)
+		if elem.Kind() == reflect.Interface {
+			elem = elem.Elem()
+		}
+		elems = append(elems, &Value{val: elem})
+	}
+	return elems, nil
+}
+
+// mapArg returns the map argument along with its keys, in key order.
+func mapArg(args []*Value, i int) (reflect.Value, []reflect.Value, error) {
+	rv := args[i].getResolvedValue()
+	if rv.Kind() != reflect.Map {
+		return reflect.Value{}, nil, fmt.Errorf("argument %d must be a map (not %s)", i+1, kindOf(args[i]))
+	}
+	keys := rv.MapKeys()
+	sortKeys(keys)
+	return rv, keys, nil
+}
+
+func builtinLen(args []*Value) (*Value, error) {
+	switch args[0].getResolvedValue().Kind() {
+	case reflect.Invalid, reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+		return AsValue(args[0].Len()), nil
+	}
+	return nil, fmt.Errorf("argument 1 has no length (it is %s)", kindOf(args[0]))
+}
+
+// stringFunc turns fn into a builtin taking and returning a string.
+func stringFunc(fn func(string) string) func([]*Value) (*Value, error) {
+	return func(args []*Value) (*Value, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(fn(s)), nil
+	}
+}
+
+// stringPredicate turns fn into a builtin testing two strings.
+func stringPredicate(fn func(string, string) bool) func([]*Value) (*Value, error) {
+	return func(args []*Value) (*Value, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		other, err := stringArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(fn(s, other)), nil
+	}
+}
+
+// builtinTrim removes the leading and trailing white space, or the
+// characters of its second argument.
+func builtinTrim(args []*Value) (*Value, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return AsValue(strings.TrimSpace(s)), nil
+	}
+	cutset, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(strings.Trim(s, cutset)), nil
+}
+
+// builtinContains looks for a substring in a string, and for an element
+// (or a key) in a slice, array or map, as the in operator does.
+func builtinContains(args []*Value) (*Value, error) {
+	switch args[0].getResolvedValue().Kind() {
+	case reflect.String:
+		sub, err := stringArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(strings.Contains(args[0].String(), sub)), nil
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return AsValue(args[0].Contains(args[1])), nil
+	}
+	return nil, fmt.Errorf("argument 1 must be a string, a slice, an array or a map (not %s)", kindOf(args[0]))
+}
+
+func builtinSplit(args []*Value) (*Value, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(strings.Split(s, sep)), nil
+}
+
+func builtinJoin(args []*Value) (*Value, error) {
+	elems, err := items(args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		if elem.IsString() {
+			parts = append(parts, elem.String())
+		} else {
+			parts = append(parts, fmt.Sprint(elem.Interface()))
+		}
+	}
+	return AsValue(strings.Join(parts, sep)), nil
+}
+
+func builtinKeys(args []*Value) (*Value, error) {
+	_, keys, err := mapArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key.Interface())
+	}
+	return AsValue(list), nil
+}
+
+// builtinValues lists the values of a map, in the order of its keys.
+func builtinValues(args []*Value) (*Value, error) {
+	m, keys, err := mapArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, m.MapIndex(key).Interface())
+	}
+	return AsValue(list), nil
+}
+
+// extremum returns min (sign -1) or max (sign 1) of its numbers or
+// strings, given as arguments or as a single slice argument.
+func extremum(sign int) func([]*Value) (*Value, error) {
+	return func(args []*Value) (*Value, error) {
+		candidates := args
+		if len(args) == 1 {
+			elems, err := items(args[0], 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(elems) == 0 {
+				return nil, fmt.Errorf("argument 1 is empty")
+			}
+			candidates = elems
+		}
+		best := candidates[0]
+		for _, candidate := range candidates[1:] {
+			c, err := compareValues(candidate, best)
+			if err != nil {
+				return nil, err
+			}
+			if c*sign > 0 {
+				best = candidate
+			}
+		}
+		return AsValue(best.Interface()), nil
+	}
+}
+
+// builtinAbs keeps the numeric type of its argument.
+func builtinAbs(args []*Value) (*Value, error) {
+	if !args[0].IsNumber() {
+		return nil, fmt.Errorf("argument 1 must be a number (not %s)", kindOf(args[0]))
+	}
+	if compareNumbers(args[0], AsValue(0)) < 0 {
+		return negateNumber(args[0])
+	}
+	return AsValue(args[0].Interface()), nil
+}
+
+// maxRoundPlaces bounds the decimal places of round, 10^places must be a
+// float64.
+const maxRoundPlaces = 308
+
+// builtinRound rounds half away from zero, to the number of decimal places
+// given as second argument (0 by default). Integers are left as they are.
+func builtinRound(args []*Value) (*Value, error) {
+	if !args[0].IsNumber() {
+		return nil, fmt.Errorf("argument 1 must be a number (not %s)", kindOf(args[0]))
+	}
+	places := 0
+	if len(args) == 2 {
+		if !args[1].IsInteger() {
+			return nil, fmt.Errorf("argument 2 must be an integer (not %s)", kindOf(args[1]))
+		}
+		places = args[1].Integer()
+		if places < -maxRoundPlaces || places > maxRoundPlaces {
+			return nil, fmt.Errorf("argument 2 must be between %d and %d (not %d)", -maxRoundPlaces, maxRoundPlaces, places)
+		}
+	}
+	if !args[0].IsFloat() {
+		return AsValue(args[0].Interface()), nil
+	}
+	scale := math.Pow(10, float64(places))
+	scaled := args[0].Float() * scale
+	if math.IsInf(scaled, 0) {
+		// Too many places for the precision of a float, nothing to round
+		return AsValue(args[0].Float()), nil
+	}
+	return AsValue(math.Round(scaled) / scale), nil
+}
+
+func builtinNow(args []*Value) (*Value, error) {
+	return AsValue(time.Now()), nil
+}
+
+// builtinDuration parses a duration such as "1h30m", see time.ParseDuration.
+func builtinDuration(args []*Value) (*Value, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(d), nil
+}
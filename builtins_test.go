@@ -0,0 +1,115 @@
+package el_test
+
+import (
+	"testing"
+	"time"
+
+	el "github.com/runcom/el"
+	"github.com/stretchr/testify/assert"
+)
+
+// Len would be hidden by the len builtin
+func (s Stats) Len() int {
+	return -1
+}
+
+func TestBuiltins(t *testing.T) {
+	s := &Stats{
+		Title:  "  Hello, World ",
+		Views:  7,
+		Score:  2.5,
+		Delta:  -3,
+		Weight: 2.345,
+		Tags:   []string{"go", "el", "patch"},
+	}
+	u := &User{
+		Name:      "ゆき",
+		BizState:  map[string]int{"b": 2, "a": 1},
+		ImgIDList: []int{3, 1, 2},
+	}
+
+	cases := []struct {
+		target   interface{}
+		exp      string
+		expected interface{}
+	}{
+		{s, `len(Tags)`, 3},
+		{s, `len(Tags) > 0 && upper(trim(Title)) == "HELLO, WORLD"`, true},
+		{u, `len(Name)`, 2},
+		{u, `len(BizState)`, 2},
+		{u, `len(nil)`, 0},
+		{s, `lower("ÉL")`, "él"},
+		{s, `trim(Title)`, "Hello, World"},
+		{s, `trim("--x--", "-")`, "x"},
+		{s, `contains(Title, "World")`, true},
+		{s, `contains(Tags, "el")`, true},
+		{s, `contains(Tags, "x")`, false},
+		{u, `contains(BizState, "a")`, true},
+		{s, `startsWith(trim(Title), "Hell")`, true},
+		{s, `endsWith(Title, "World")`, false},
+		{s, `split("a,b,c", ",")[1]`, "b"},
+		{s, `join(Tags, "/")`, "go/el/patch"},
+		{u, `join(ImgIDList, ",")`, "3,1,2"},
+		{u, `keys(BizState)`, []interface{}{"a", "b"}},
+		{u, `values(BizState)`, []interface{}{1, 2}},
+		{u, `min(ImgIDList)`, 1},
+		{u, `max(ImgIDList)`, 3},
+		{s, `max(Views, 3, 9)`, 9},
+		{s, `min(Views, 3, 9)`, 3},
+		{s, `min(Tags)`, "el"},
+		{s, `abs(Delta)`, int8(3)},
+		{s, `abs(-2.5)`, 2.5},
+		{s, `abs(Views)`, uint32(7)},
+		{s, `round(Weight)`, 2.0},
+		{s, `round(Weight, 2)`, 2.35},
+		{s, `round(Views)`, uint32(7)},
+		{s, `round(1.5, 400 - 92)`, 1.5},
+		{s, `round(1e300, 100)`, 1e300},
+		{s, `round(1.5, -308)`, 0.0},
+		{s, `abs(-9223372036854775807)`, 9223372036854775807},
+		{s, `duration("1h30m")`, 90 * time.Minute},
+		{s, `duration("2s") > duration("1s")`, true},
+		{s, `len(Tags[?(len(@) == 2)])`, 2},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(c.target)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	exp := el.Expression(`now()`)
+	v, err := exp.Execute(s)
+	if assert.NoError(t, err) {
+		assert.WithinDuration(t, time.Now(), v.Interface().(time.Time), time.Minute)
+	}
+
+	// The builtin comes before the Len method of the target
+	exp = el.Expression(`len()`)
+	_, err = exp.Execute(s)
+	assert.Error(t, err)
+
+	for _, e := range []string{
+		`len(Views)`,
+		`upper(Views)`,
+		`trim(Title, 1)`,
+		`contains(Views, 1)`,
+		`join(Title, ",")`,
+		`keys(Tags)`,
+		`min()`,
+		`min(Title, 1)`,
+		`abs("x")`,
+		`round(Weight, 1.5)`,
+		`round(1.5, 400)`,
+		`round(1.5, -400)`,
+		`abs(-9223372036854775807 - 1)`,
+		`abs(Delta - 125)`,
+		`duration("soon")`,
+		`now(1)`,
+	} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(s)
+		assert.Error(t, err, e)
+	}
+}
@@ -0,0 +1,493 @@
+package el
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	float64Type = reflect.TypeOf(float64(0))
+	mapType     = reflect.TypeOf(map[string]interface{}{})
+	valueType   = reflect.TypeOf(new(Value))
+)
+
+// CompileFor compiles expr and checks it against targets of type t: the
+// fields and methods of its paths must exist, only slices, arrays, strings
+// and maps may be indexed, by integers or keys of the map, and methods must
+// be called with arguments of their types. The type of the result is then
+// known, see Program.Type.
+//
+// Parts of a path whose type is only known at run time, such as interface
+// values, variables or what "..name" finds, aren't checked.
+func CompileFor(t reflect.Type, expr string) (*Program, error) {
+	return CompileForEnv(nil, t, expr)
+}
+
+// CompileForEnv is CompileFor for expressions evaluated in env, the calls
+// of the functions registered in env are checked as well.
+func CompileForEnv(env *Env, t reflect.Type, expr string) (*Program, error) {
+	prog, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checker{env: env}
+	typ, perr := c.check(prog.root, t)
+	if perr != nil {
+		perr.Expression = expr
+		return nil, perr
+	}
+	prog.typ = typ
+	return prog, nil
+}
+
+// checker walks the nodes of a program along with the types of the values
+// they'll evaluate to. A nil type is a type only known at run time.
+type checker struct {
+	env *Env
+}
+
+// check returns the type node evaluates to against a target of type t.
+func (c *checker) check(node IEvaluator, t reflect.Type) (reflect.Type, *Error) {
+	switch n := node.(type) {
+	case *intResolver:
+		return intType, nil
+	case *floatResolver:
+		return float64Type, nil
+	case *stringResolver:
+		return stringType, nil
+	case *boolResolver:
+		return boolType, nil
+	case *nilResolver:
+		return nil, nil
+	case *arrayResolver:
+		for _, item := range n.items {
+			if _, err := c.check(item, t); err != nil {
+				return nil, err
+			}
+		}
+		return listType, nil
+	case *mapResolver:
+		for _, value := range n.values {
+			if _, err := c.check(value, t); err != nil {
+				return nil, err
+			}
+		}
+		return mapType, nil
+	case *unaryOperation:
+		operand, err := c.check(n.operand, t)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" || n.op == "not" {
+			return boolType, nil
+		}
+		return operand, nil
+	case *binaryOperation:
+		return c.checkBinary(n, t)
+	case *matchesOperation:
+		if _, err := c.check(n.left, t); err != nil {
+			return nil, err
+		}
+		if _, err := c.check(n.pattern, t); err != nil {
+			return nil, err
+		}
+		return boolType, nil
+	case *conditionalOperation:
+		if _, err := c.check(n.cond, t); err != nil {
+			return nil, err
+		}
+		then, err := c.check(n.then, t)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := c.check(n.otherwise, t)
+		if err != nil {
+			return nil, err
+		}
+		return sameType(then, otherwise), nil
+	case *variableResolver:
+		return c.checkPath(n, t)
+	}
+	return nil, nil
+}
+
+// checkBinary returns the type of a binary operation. Operands of the
+// wrong types are only reported at run time, as numbers of any kind mix.
+func (c *checker) checkBinary(b *binaryOperation, t reflect.Type) (reflect.Type, *Error) {
+	left, err := c.check(b.left, t)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.check(b.right, t)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "==", "!=", "<", ">", "<=", ">=", "in", "not in", "&&", "and", "||", "or":
+		return boolType, nil
+	case "??":
+		return sameType(left, right), nil
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	switch {
+	case b.op == "+" && left.Kind() == reflect.String && right.Kind() == reflect.String:
+		return stringType, nil
+	case isFloatKind(left.Kind()) || isFloatKind(right.Kind()):
+		return float64Type, nil
+	case b.op == "**":
+		// A negative exponent gives a float
+		return nil, nil
+	case isIntegerKind(left.Kind()) && isIntegerKind(right.Kind()):
+		return integerResultType(b.op, left, right), nil
+	}
+	return nil, nil
+}
+
+// checkPath follows the parts of a path through the type graph of t.
+func (c *checker) checkPath(vr *variableResolver, t reflect.Type) (reflect.Type, *Error) {
+	current := t
+	fansOut := false
+
+	for idx, part := range vr.parts {
+		if part.isDeep {
+			// What is found depends on the data
+			return listType, nil
+		}
+
+		var err error
+		current, err = c.checkPart(vr, idx, part, current, t)
+		if err != nil {
+			return nil, vr.positionError(err)
+		}
+		if current == nil {
+			// Only known at run time, the rest of the path can't be checked
+			if vr.fansOut() {
+				return listType, nil
+			}
+			return nil, nil
+		}
+
+		if part.fansOut() {
+			fansOut = true
+			current, err = elemType(vr, current)
+			if err != nil {
+				return nil, vr.positionError(err)
+			}
+			if part.filter != nil {
+				if _, perr := c.check(part.filter, current); perr != nil {
+					return nil, perr
+				}
+			}
+		}
+	}
+
+	if fansOut {
+		return listType, nil
+	}
+	return current, nil
+}
+
+// checkPart returns the type the part leads to from current, t being the
+// type of the target.
+func (c *checker) checkPart(vr *variableResolver, idx int, part *variablePart, current, t reflect.Type) (reflect.Type, error) {
+	// Built-in and registered functions come first, as in resolve
+	if idx == 0 && part.typ == varTypeIdent && part.isFunctionCall {
+		if fn, ok := builtins[part.s]; ok {
+			if len(part.callingArgs) < fn.minArgs || (fn.maxArgs >= 0 && len(part.callingArgs) > fn.maxArgs) {
+				return nil, fmt.Errorf("Function '%s' takes %s argument(s), not %d", part.s, fn.arity(), len(part.callingArgs))
+			}
+			if err := c.checkArgs(part, t); err != nil {
+				return nil, err
+			}
+			return fn.typ, nil
+		}
+		if fn, ok := c.env.lookup(part.s); ok {
+			return c.checkCall(vr, part, fn.Type(), t)
+		}
+	}
+
+	if current == nil || current.Kind() == reflect.Interface {
+		return nil, nil
+	}
+
+	isFunc := false
+	switch part.typ {
+	case varTypeVar:
+		// Variables are only known at run time
+		return nil, nil
+	case varTypeIdent:
+		// Methods are looked up before resolving the pointer, as in step
+		if index, ok := infoOf(current).methods[part.s]; ok {
+			current = methodType(current.Method(index))
+			isFunc = true
+			break
+		}
+		if current.Kind() == reflect.Ptr {
+			current = current.Elem()
+		}
+		switch current.Kind() {
+		case reflect.Struct:
+			index, ok := infoOf(current).fields[part.s]
+			if !ok {
+				return nil, fmt.Errorf("Unknown field or method '%s' of type %s (variable %s)", part.s, current.String(), vr.String())
+			}
+			current = current.FieldByIndex(index).Type
+		case reflect.Map:
+			if !stringType.AssignableTo(current.Key()) {
+				return nil, fmt.Errorf("Can't access a key by name on type %s (variable %s)", current.String(), vr.String())
+			}
+			current = current.Elem()
+		case reflect.Interface:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("Can't access a field by name on type %s (variable %s)", current.Kind().String(), vr.String())
+		}
+	case varTypeInt:
+		if current.Kind() == reflect.Ptr {
+			current = current.Elem()
+		}
+		switch current.Kind() {
+		case reflect.String:
+		case reflect.Array, reflect.Slice:
+			current = current.Elem()
+		default:
+			return nil, fmt.Errorf("Can't access an index on type %s (variable %s)", current.Kind().String(), vr.String())
+		}
+	case varTypeIndex:
+		if current.Kind() == reflect.Ptr {
+			current = current.Elem()
+		}
+	}
+
+	if current.Kind() == reflect.Interface && (part.isIndexCall || part.isSliceCall || part.isSelectCall || part.isFunctionCall || part.fansOut()) {
+		return nil, nil
+	}
+
+	if part.isIndexCall {
+		index, err := c.checkArg(part.indexArg, t)
+		if err != nil {
+			return nil, err
+		}
+		dynamic := index == nil || index.Kind() == reflect.Interface
+		switch current.Kind() {
+		case reflect.String, reflect.Array, reflect.Slice:
+			if !dynamic && !isIntegerKind(index.Kind()) {
+				return nil, fmt.Errorf("Index of '%s' must be an integer (not %s)", vr.String(), index.String())
+			}
+			if current.Kind() != reflect.String {
+				current = current.Elem()
+			}
+		case reflect.Map:
+			if !dynamic && !acceptsKey(current.Key(), index) {
+				return nil, fmt.Errorf("Key of '%s' must be a %s (not %s)", vr.String(), current.Key().String(), index.String())
+			}
+			current = current.Elem()
+		default:
+			return nil, fmt.Errorf("'%s' can not be index access (it is %s)", vr.String(), current.Kind().String())
+		}
+	}
+
+	if part.isSelectCall {
+		if _, err := c.checkArg(part.selectArg, t); err != nil {
+			return nil, err
+		}
+		elem, err := elemType(vr, current)
+		if err != nil {
+			return nil, err
+		}
+		holder := elem
+		if holder.Kind() == reflect.Ptr {
+			holder = holder.Elem()
+		}
+		if holder.Kind() == reflect.Struct {
+			if _, ok := infoOf(holder).fields[part.selectKey]; !ok {
+				return nil, fmt.Errorf("Unknown field '%s' of type %s (variable %s)", part.selectKey, holder.String(), vr.String())
+			}
+		}
+		current = elem
+	}
+
+	if part.isSliceCall {
+		for _, bound := range []functionCallArgument{part.sliceFrom, part.sliceTo} {
+			if bound == nil {
+				continue
+			}
+			typ, err := c.checkArg(bound, t)
+			if err != nil {
+				return nil, err
+			}
+			if typ != nil && typ.Kind() != reflect.Interface && !isIntegerKind(typ.Kind()) {
+				return nil, fmt.Errorf("Slice bound of '%s' must be an integer (not %s)", vr.String(), typ.String())
+			}
+		}
+		switch current.Kind() {
+		case reflect.String, reflect.Slice:
+		case reflect.Array:
+			current = reflect.SliceOf(current.Elem())
+		default:
+			return nil, fmt.Errorf("'%s' can not be sliced (it is %s)", vr.String(), current.Kind().String())
+		}
+	}
+
+	// Methods and func fields are called even without parentheses
+	if part.isFunctionCall || isFunc || current.Kind() == reflect.Func {
+		if current.Kind() != reflect.Func {
+			return nil, fmt.Errorf("'%s' is not a function (it is %s)", vr.String(), current.Kind().String())
+		}
+		return c.checkCall(vr, part, current, t)
+	}
+
+	return current, nil
+}
+
+// checkCall checks the arguments of part against the function type fn and
+// returns the type of its result.
+func (c *checker) checkCall(vr *variableResolver, part *variablePart, fn, t reflect.Type) (reflect.Type, error) {
+	if len(part.callingArgs) != fn.NumIn() && !(len(part.callingArgs) >= fn.NumIn()-1 && fn.IsVariadic()) {
+		return nil, fmt.Errorf("Function input argument count (%d) of '%s' must be equal to the calling argument count (%d).",
+			fn.NumIn(), vr.String(), len(part.callingArgs))
+	}
+	if fn.NumOut() != 1 {
+		return nil, fmt.Errorf("'%s' must have exactly 1 output argument", vr.String())
+	}
+
+	for i, arg := range part.callingArgs {
+		argType, err := c.checkArg(arg, t)
+		if err != nil {
+			return nil, err
+		}
+
+		var param reflect.Type
+		if fn.IsVariadic() && i >= fn.NumIn()-1 {
+			param = fn.In(fn.NumIn() - 1).Elem()
+		} else {
+			param = fn.In(i)
+		}
+		if argType == nil || argType.Kind() == reflect.Interface || param == valueType {
+			continue
+		}
+		if !acceptsArgument(param, argType) {
+			return nil, fmt.Errorf("Function input argument %d of '%s' must be of type %s or *Value (not %s).",
+				i, vr.String(), param.String(), argType.String())
+		}
+	}
+
+	if fn.Out(0) == valueType {
+		return nil, nil
+	}
+	return fn.Out(0), nil
+}
+
+// checkArgs checks the arguments of part, whatever their types.
+func (c *checker) checkArgs(part *variablePart, t reflect.Type) error {
+	for _, arg := range part.callingArgs {
+		if _, err := c.checkArg(arg, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkArg checks an argument, index or bound of a path.
+func (c *checker) checkArg(arg functionCallArgument, t reflect.Type) (reflect.Type, error) {
+	node, ok := arg.(IEvaluator)
+	if !ok {
+		return nil, nil
+	}
+	typ, perr := c.check(node, t)
+	if perr != nil {
+		return nil, perr
+	}
+	return typ, nil
+}
+
+// positionError places err at the path, unless it already is an *Error
+// raised by a nested expression.
+func (vr *variableResolver) positionError(err error) *Error {
+	if perr, ok := err.(*Error); ok {
+		return perr
+	}
+	return NewError(err.Error(), vr.locationToken)
+}
+
+// acceptsKey reports whether values of type arg can index maps whose keys
+// are of type key, as mapKey converts them.
+func acceptsKey(key, arg reflect.Type) bool {
+	switch {
+	case arg.AssignableTo(key):
+		return true
+	case key.Kind() == reflect.String && isIntegerKind(arg.Kind()):
+		return true
+	case (isIntegerKind(arg.Kind()) || isFloatKind(arg.Kind())) && key.Kind() != reflect.String:
+		// Whether the number fits in the key type is only known at run time
+		return arg.ConvertibleTo(key)
+	}
+	return arg.ConvertibleTo(key) && arg.Kind() == key.Kind()
+}
+
+// acceptsArgument reports whether arguments of type arg can be given for a
+// parameter of type param other than *Value, as callArgument converts
+// them. Whether a number fits in param is only known at run time.
+func acceptsArgument(param, arg reflect.Type) bool {
+	switch {
+	case arg == param:
+		return true
+	case param.Kind() == reflect.Interface:
+		return arg.AssignableTo(param)
+	}
+	return isNumberKind(arg.Kind()) && isNumberKind(param.Kind())
+}
+
+// elemType returns the type of the elements of a slice, array or map.
+func elemType(vr *variableResolver, t reflect.Type) (reflect.Type, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return t.Elem(), nil
+	}
+	return nil, fmt.Errorf("'%s' can not be iterated (it is %s)", vr.String(), t.Kind().String())
+}
+
+// methodType returns the type of a method without its receiver, as
+// reflect.Value.MethodByName gives it.
+func methodType(method reflect.Method) reflect.Type {
+	in := make([]reflect.Type, 0, method.Type.NumIn()-1)
+	for i := 1; i < method.Type.NumIn(); i++ {
+		in = append(in, method.Type.In(i))
+	}
+	out := make([]reflect.Type, 0, method.Type.NumOut())
+	for i := 0; i < method.Type.NumOut(); i++ {
+		out = append(out, method.Type.Out(i))
+	}
+	return reflect.FuncOf(in, out, method.Type.IsVariadic())
+}
+
+// sameType returns a and b when they're the same, nil otherwise.
+func sameType(a, b reflect.Type) reflect.Type {
+	if a == b {
+		return a
+	}
+	return nil
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return isIntegerKind(k) || isFloatKind(k)
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return isUnsignedKind(k)
+}
@@ -0,0 +1,142 @@
+package el_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	el "github.com/runcom/el"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileFor(t *testing.T) {
+	userType := reflect.TypeOf(&User{})
+	list := reflect.TypeOf([]interface{}{})
+
+	cases := []struct {
+		exp      string
+		expected reflect.Type
+	}{
+		{`Name`, reflect.TypeOf("")},
+		{`name`, reflect.TypeOf("")},
+		{`Images[0].Content`, reflect.TypeOf("")},
+		{`Images[-1]`, reflect.TypeOf(&Image{})},
+		{`ImgIdx["a"]?.Content`, reflect.TypeOf("")},
+		{`BizState.x + 1`, reflect.TypeOf(0)},
+		{`ImgIDList[0] * 1.5`, reflect.TypeOf(float64(0))},
+		{`Name[1:]`, reflect.TypeOf("")},
+		{`Name + "!"`, reflect.TypeOf("")},
+		{`FindImage(1).Content`, reflect.TypeOf("")},
+		{`CountOf(Images)`, reflect.TypeOf(0)},
+		{`len(Images) > 0 && Name != ""`, reflect.TypeOf(false)},
+		{`upper(Name)`, reflect.TypeOf("")},
+		{`Images[*].Content`, list},
+		{`Images[?(Content matches "png$")].Content`, list},
+		{`Name ?? "guest"`, reflect.TypeOf("")},
+		{`ImgIdx[ImgIDList[0]].Content`, reflect.TypeOf("")},
+		{`Images[BizState.x]`, reflect.TypeOf(&Image{})},
+		{`Images[$i]`, reflect.TypeOf(&Image{})},
+		{`$user.Anything`, nil},
+		{`min(ImgIDList)`, nil},
+		{`..Content`, list},
+	}
+	for _, c := range cases {
+		prog, err := el.CompileFor(userType, c.exp)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, prog.Type(), c.exp)
+		}
+	}
+
+	profile := reflect.TypeOf(Profile{})
+	for _, e := range []string{`Avatar?.Content`, `FirstImage().Content`, `Any.Whatever[0]`, `Extra[*].Content`} {
+		_, err := el.CompileFor(profile, e)
+		assert.NoError(t, err, e)
+	}
+
+	errors := []struct {
+		exp     string
+		message string
+	}{
+		{`Nmae`, "Unknown field or method 'Nmae'"},
+		{`Images[0].Content.Size`, "Can't access a field by name on type string"},
+		{`ImgIDList.Content`, "Can't access a field by name on type slice"},
+		{`Name[0:1].X`, "Can't access a field by name on type string"},
+		{`Images[0][1]`, "can not be index access"},
+		{`FindImage("1")`, "must be of type int"},
+		{`FindImage()`, "argument count"},
+		{`Images[?(Size > 0)]`, "Unknown field or method 'Size'"},
+		{`Name[*]`, "can not be iterated"},
+		{`upper()`, "Function 'upper' takes 1 argument(s)"},
+		{`BizState[Nope]`, "Unknown field or method 'Nope'"},
+		{`Images[Size=1]`, "Unknown field 'Size'"},
+		{`len(Images) > 0 && Nmae == ""`, "Unknown field or method 'Nmae'"},
+		{`Images["x"]`, "Index of 'Images' must be an integer"},
+		{`Name[1.5]`, "must be an integer"},
+		{`ImgIDList[:"2"]`, "Slice bound of 'ImgIDList' must be an integer"},
+		{`ImgIdx[1.5]`, "Key of 'ImgIdx' must be a string"},
+		{`ImgIdx[Images]`, "Key of 'ImgIdx' must be a string"},
+	}
+	for _, c := range errors {
+		_, err := el.CompileFor(userType, c.exp)
+		if assert.Error(t, err, c.exp) {
+			assert.IsType(t, &el.Error{}, err, c.exp)
+			assert.True(t, strings.Contains(err.Error(), c.message), "%s: %s", c.exp, err)
+			assert.Contains(t, err.Error(), c.exp)
+		}
+	}
+
+	// Checked programs are evaluated as any other
+	prog, err := el.CompileFor(userType, `Images[?(Content != "")].Content`)
+	if assert.NoError(t, err) {
+		v, err := prog.Eval(&User{Images: []*Image{{"a.png"}, {""}}})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []interface{}{"a.png"}, v.Interface())
+		}
+	}
+
+	// Programs which aren't checked have no type
+	prog, err = el.Compile(`Name`)
+	if assert.NoError(t, err) {
+		assert.Nil(t, prog.Type())
+	}
+}
+
+func TestCompileForEnv(t *testing.T) {
+	env := el.NewEnv()
+	assert.NoError(t, env.Func("slugify", func(s string) string {
+		return strings.ToLower(s)
+	}))
+	assert.NoError(t, env.Func("str", func(s fmt.Stringer) string {
+		return s.String()
+	}))
+	assert.NoError(t, env.Func("isBig", func(n int64) bool {
+		return n > 100
+	}))
+	userType := reflect.TypeOf(User{})
+
+	prog, err := el.CompileForEnv(env, userType, `slugify(Name)`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, reflect.TypeOf(""), prog.Type())
+	}
+
+	prog, err = el.CompileForEnv(env, userType, `str(duration("1m"))`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, reflect.TypeOf(""), prog.Type())
+	}
+
+	// Numbers are converted to the number type of the parameter
+	prog, err = el.CompileForEnv(env, userType, `isBig(ImgIDList[0]) && isBig(2)`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, reflect.TypeOf(true), prog.Type())
+	}
+
+	for _, e := range []string{`slugify(1)`, `slugify()`, `slugify(Name).X`, `str(1)`, `str(Name)`, `isBig(Name)`} {
+		_, err := el.CompileForEnv(env, userType, e)
+		assert.Error(t, err, e)
+	}
+
+	// Without the environment, slugify is looked up as a method
+	_, err = el.CompileFor(userType, `slugify(Name)`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,169 @@
+package el
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+)
+
+// nameRegexp matches the names functions and variables can be registered
+// under, the same as the names of an expression.
+var nameRegexp = regexp.MustCompile(`\A[\p{L}_][\p{L}\p{Nd}_]*\z`)
+
+// reservedNames can't be used as function or variable names, they are
+// keywords or operators in expressions.
+var reservedNames = map[string]bool{
+	"true": true, "false": true, "nil": true, "null": true,
+	"in": true, "matches": true, "and": true, "or": true, "not": true,
+}
+
+// Env is an evaluation environment, it holds the functions expressions can
+// call beside the builtins and the methods of the target, and the variables
+// they refer to as $name. The zero Env is empty and ready to use, an Env
+// can be shared by many goroutines.
+type Env struct {
+	parent *Env
+
+	mu    sync.RWMutex
+	funcs map[string]reflect.Value
+	vars  map[string]interface{}
+}
+
+// NewEnv returns an empty environment.
+func NewEnv() *Env {
+	return &Env{}
+}
+
+// Func registers fn, to be called as name(...) from expressions evaluated
+// in the environment. As for methods, fn must return exactly one value and
+// each of its parameters is given either the *Value of the argument or the
+// argument itself, numbers being converted to the number type of the
+// parameter, to integers only when they fit in it. Parameters no argument
+// can be given, such as channels, functions or a Value rather than *Value,
+// are reported now, the types of the arguments when fn is called.
+func (e *Env) Func(name string, fn interface{}) error {
+	if !validName(name) {
+		return fmt.Errorf("Function name '%s' is not a valid name", name)
+	}
+	if _, ok := builtins[name]; ok {
+		return fmt.Errorf("Function '%s' is a builtin", name)
+	}
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return fmt.Errorf("Function '%s' must be a function (not %T)", name, fn)
+	}
+	if err := checkSignature(rv.Type()); err != nil {
+		return fmt.Errorf("Function '%s' %s", name, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.funcs[name]; ok {
+		return fmt.Errorf("Function '%s' is already registered", name)
+	}
+	if e.funcs == nil {
+		e.funcs = make(map[string]reflect.Value)
+	}
+	e.funcs[name] = rv
+	return nil
+}
+
+// Var sets the variable name, referred to as $name in expressions.
+func (e *Env) Var(name string, value interface{}) error {
+	if !validName(name) {
+		return fmt.Errorf("Variable name '%s' is not a valid name", name)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.vars == nil {
+		e.vars = make(map[string]interface{})
+	}
+	e.vars[name] = value
+	return nil
+}
+
+// With returns an environment holding a copy of vars on top of e, e.g. the
+// variables of a request on top of an Env shared by all requests. Functions
+// and variables of e stay visible, unless vars hides them.
+func (e *Env) With(vars map[string]interface{}) *Env {
+	child := &Env{parent: e, vars: make(map[string]interface{}, len(vars))}
+	for name, value := range vars {
+		child.vars[name] = value
+	}
+	return child
+}
+
+// lookup returns the function registered under name.
+func (e *Env) lookup(name string) (reflect.Value, bool) {
+	for ; e != nil; e = e.parent {
+		e.mu.RLock()
+		fn, ok := e.funcs[name]
+		e.mu.RUnlock()
+		if ok {
+			return fn, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// variable returns the value of the variable name.
+func (e *Env) variable(name string) (interface{}, bool) {
+	for ; e != nil; e = e.parent {
+		e.mu.RLock()
+		value, ok := e.vars[name]
+		e.mu.RUnlock()
+		if ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// validName reports whether functions and variables can be named name.
+func validName(name string) bool {
+	return nameRegexp.MatchString(name) && !reservedNames[name]
+}
+
+// checkSignature tells why a function of type t can't be called from an
+// expression: its parameters, the variadic one included, must be types
+// arguments can have once evaluated.
+func checkSignature(t reflect.Type) error {
+	if t.NumOut() != 1 {
+		return fmt.Errorf("must have exactly 1 output argument (not %d)", t.NumOut())
+	}
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		if in == valueType.Elem() {
+			return fmt.Errorf("input argument %d can't be given by an expression (it is %s, not *%s)", i, in.String(), in.String())
+		}
+		switch in.Kind() {
+		case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			return fmt.Errorf("input argument %d can't be given by an expression (it is %s)", i, in.String())
+		}
+	}
+	return nil
+}
+
+// scope is what an expression is evaluated against: the target and the
+// environment. Nodes pass it along as their target.
+type scope struct {
+	target interface{}
+	env    *Env
+}
+
+// scopeOf returns target as a scope, a plain target having no environment.
+func scopeOf(target interface{}) *scope {
+	if sc, ok := target.(*scope); ok {
+		return sc
+	}
+	return &scope{target: target}
+}
+
+// with returns the scope of target in the same environment.
+func (sc *scope) with(target interface{}) *scope {
+	return &scope{target: target, env: sc.env}
+}
@@ -0,0 +1,245 @@
+package el_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	el "github.com/runcom/el"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvFunc(t *testing.T) {
+	env := el.NewEnv()
+	assert.NoError(t, env.Func("slugify", func(s string) string {
+		return strings.ToLower(strings.Replace(s, " ", "-", -1))
+	}))
+	assert.NoError(t, env.Func("sum", func(nums ...int) int {
+		total := 0
+		for _, n := range nums {
+			total += n
+		}
+		return total
+	}))
+	assert.NoError(t, env.Func("describe", func(v *el.Value) *el.Value {
+		return el.AsValue(fmt.Sprintf("%d item(s)", v.Len()))
+	}))
+	assert.NoError(t, env.Func("isPng", func(s string) bool {
+		return strings.HasSuffix(s, ".png")
+	}))
+	assert.NoError(t, env.Func("str", func(s fmt.Stringer) string {
+		return s.String()
+	}))
+	assert.NoError(t, env.Func("strs", func(s ...fmt.Stringer) int {
+		return len(s)
+	}))
+	assert.NoError(t, env.Func("isBig", func(n int64) bool {
+		return n > 100
+	}))
+	assert.NoError(t, env.Func("half", func(f float32) float32 {
+		return f / 2
+	}))
+	assert.NoError(t, env.Func("byte", func(b uint8) int {
+		return int(b)
+	}))
+	assert.NoError(t, env.Func("isNil", func(v interface{}) bool {
+		return v == nil
+	}))
+
+	u := &User{
+		Name:      "Hello World",
+		ImgIDList: []int{1, 2},
+		Images:    []*Image{{"1.jpg"}, {"2.png"}},
+	}
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`slugify(Name)`, "hello-world"},
+		{`slugify(Name) == "hello-world"`, true},
+		{`sum()`, 0},
+		{`sum(1, 2, ImgIDList[1])`, 5},
+		{`describe(ImgIDList)`, "2 item(s)"},
+		{`Images[?(isPng(Content))].Content`, []interface{}{"2.png"}},
+		{`ImgIDList[sum(1, -1)]`, 1},
+		{`upper(slugify(Name))`, "HELLO-WORLD"},
+		{`str(duration("1m"))`, "1m0s"},
+		{`strs(duration("1m"), duration("1s"))`, 2},
+		{`isBig(1000)`, true},
+		{`isBig(ImgIDList[0])`, false},
+		{`half(3)`, float32(1.5)},
+		{`half(0.5)`, float32(0.25)},
+		{`byte(255)`, 255},
+		{`byte(2.0)`, 2},
+		{`isNil(nil)`, true},
+		{`isNil(Name)`, false},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.ExecuteEnv(env, u)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	// Numbers of other types are converted to the number type of the
+	// parameter
+	exp := el.Expression(`isBig(Delta)`)
+	v, err := exp.ExecuteEnv(env, &Stats{Delta: 120})
+	if assert.NoError(t, err) {
+		assert.Equal(t, true, v.Interface())
+	}
+
+	// Functions are only known in their environment
+	exp = el.Expression(`slugify(Name)`)
+	_, err = exp.Execute(u)
+	assert.Error(t, err)
+	_, err = exp.ExecuteEnv(el.NewEnv(), u)
+	assert.Error(t, err)
+
+	// Arguments of the wrong type, not implementing an interface parameter
+	// or integers not fitting in it are errors rather than panics
+	for _, e := range []string{
+		`slugify()`,
+		`slugify(1)`,
+		`sum("a")`,
+		`str(1)`,
+		`strs(duration("1m"), "x")`,
+		`isBig(1.5)`,
+		`isBig("1")`,
+		`byte(-1)`,
+		`byte(256)`,
+		`slugify(nil)`,
+	} {
+		exp := el.Expression(e)
+		_, err := exp.ExecuteEnv(env, u)
+		assert.Error(t, err, e)
+	}
+
+	patcher := el.Patcher{Env: env}
+	err = patcher.PatchIt(u, el.Patch{`images[sum(0, 1)].content`: "3.gif"})
+	assert.NoError(t, err)
+	assert.Equal(t, "3.gif", u.Images[1].Content)
+}
+
+func TestEnvFuncRegistration(t *testing.T) {
+	var env el.Env
+
+	cases := []struct {
+		name string
+		fn   interface{}
+	}{
+		{"noResult", func() {}},
+		{"twoResults", func() (int, error) { return 0, nil }},
+		{"chanArg", func(c chan int) int { return 0 }},
+		{"funcVariadic", func(fns ...func()) int { return 0 }},
+		{"valueArg", func(v el.Value) int { return 0 }},
+		{"valueVariadic", func(v ...el.Value) int { return 0 }},
+		{"notAFunc", 42},
+		{"nilFunc", (func() int)(nil)},
+		{"len", func() int { return 0 }},
+		{"in", func() int { return 0 }},
+		{"1st", func() int { return 0 }},
+		{"with space", func() int { return 0 }},
+	}
+	for _, c := range cases {
+		assert.Error(t, env.Func(c.name, c.fn), c.name)
+	}
+
+	assert.NoError(t, env.Func("one", func() int { return 1 }))
+	assert.Error(t, env.Func("one", func() int { return 2 }))
+}
+
+func TestEnvConcurrency(t *testing.T) {
+	env := el.NewEnv()
+	u := &User{Name: "x"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("f%d", i)
+			assert.NoError(t, env.Func(name, func() int { return i }))
+			exp := el.Expression(name + "()")
+			for j := 0; j < 100; j++ {
+				v, err := exp.ExecuteEnv(env, u)
+				if assert.NoError(t, err) {
+					assert.Equal(t, i, v.Interface())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+type Account struct {
+	ID    int
+	Roles []string
+}
+
+type Resource struct {
+	Owner *Account
+	Tags  map[string]string
+}
+
+func TestEnvVariables(t *testing.T) {
+	shared := el.NewEnv()
+	assert.NoError(t, shared.Func("double", func(i int) int { return 2 * i }))
+	assert.NoError(t, shared.Var("admin", "admin"))
+
+	owner := &Account{ID: 1}
+	res := &Resource{Owner: owner, Tags: map[string]string{"1": "one", "2": "two"}}
+	rule := el.Expression(`Owner.ID == $user.ID || $admin in $user.Roles`)
+
+	cases := []struct {
+		user     *Account
+		exp      el.Expression
+		expected interface{}
+	}{
+		{owner, rule, true},
+		{&Account{ID: 2, Roles: []string{"admin"}}, rule, true},
+		{&Account{ID: 2, Roles: []string{"user"}}, rule, false},
+		{&Account{ID: 2}, `Tags[$user.ID]`, "two"},
+		{&Account{ID: 1}, `Tags[double($user.ID)]`, "two"},
+		{&Account{ID: 1}, `len($user.Roles)`, 0},
+		{&Account{ID: 1}, `$user.Roles[?(@ == $admin)]`, []interface{}{}},
+		{nil, `$user?.ID ?? 0`, 0},
+		{owner, `$user`, owner},
+	}
+	for _, c := range cases {
+		env := shared.With(map[string]interface{}{"user": c.user})
+		v, err := c.exp.ExecuteEnv(env, res)
+		if assert.NoError(t, err, string(c.exp)) {
+			assert.Equal(t, c.expected, v.Interface(), string(c.exp))
+		}
+	}
+
+	// Variables of a derived environment hide the shared ones and stay
+	// out of it
+	env := shared.With(map[string]interface{}{"admin": "root", "user": owner})
+	exp := el.Expression(`$admin`)
+	v, err := exp.ExecuteEnv(env, res)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "root", v.Interface())
+	}
+	exp = el.Expression(`$user`)
+	_, err = exp.ExecuteEnv(shared, res)
+	assert.Error(t, err)
+	_, err = exp.Execute(res)
+	assert.Error(t, err)
+
+	// Variables can be patched through
+	patcher := el.Patcher{Env: env}
+	err = patcher.PatchIt(res, el.Patch{
+		"$user.roles":    []string{"editor"},
+		"tags[$user.ID]": "uno",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"editor"}, owner.Roles)
+	assert.Equal(t, "uno", res.Tags["1"])
+
+	assert.Error(t, shared.Var("not", 1))
+	assert.Error(t, shared.Var("$x", 1))
+}
@@ -1,41 +1,43 @@
 package el
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/runcom/el/token"
+)
 
 type Error struct {
 	Expression string
-	Line       int
-	Column     int
-	Token      *Token
+	Cursor     int
+	Token      *token.Token
 	ErrorMsg   string
 }
 
 // Returns a nice formatted error string.
 func (e *Error) Error() string {
 	s := "[Error"
-	if e.Line > 0 {
-		s += fmt.Sprintf(" | Line %d Col %d", e.Line, e.Column)
-		if e.Token != nil {
-			s += fmt.Sprintf(" near '%s'", e.Token.Val)
+	if e.Cursor > 0 {
+		s += fmt.Sprintf(" | Col %d", e.Cursor)
+		if e.Token != nil && e.Token.Type != token.TypeEOF {
+			s += fmt.Sprintf(" near '%s'", e.Token.Value)
 		}
 	}
+	if e.Expression != "" {
+		s += fmt.Sprintf(" | %s", e.Expression)
+	}
 	s += "] "
 	s += e.ErrorMsg
 	return s
 }
 
-func NewError(msg string, token *Token) *Error {
-	var line, col int
-	if token != nil {
-		// No tokens available
-		// TODO: Add location (from where?)
-		line = token.Line
-		col = token.Col
+func NewError(msg string, tok *token.Token) *Error {
+	var cursor int
+	if tok != nil {
+		cursor = tok.Cursor
 	}
 	return &Error{
-		Line:     line,
-		Column:   col,
-		Token:    token,
+		Cursor:   cursor,
+		Token:    tok,
 		ErrorMsg: msg,
 	}
 }
@@ -1,32 +1,42 @@
 package el
 
-import "strings"
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 // Expression to Patch
 type Expression string
 
 func (path *Expression) Execute(target interface{}) (*Value, error) {
+	return path.ExecuteEnv(nil, target)
+}
 
-	toks, err := Lex(string(*path))
-	if err != nil {
-		return nil, err
-	}
-
-	parser := NewParser(toks)
-
-	exp, err := parser.ParseExp()
+// ExecuteEnv executes the expression against target in env, so it can call
+// the functions registered in env.
+func (path *Expression) ExecuteEnv(env *Env, target interface{}) (*Value, error) {
+	prog, err := Compile(string(*path))
 	if err != nil {
 		return nil, err
 	}
+	return prog.EvalEnv(env, target)
+}
 
-	value, err := exp.Evaluate(target)
+// ExecuteAll returns every value the expression reaches in target, each
+// one with its own setter, e.g. all the nick names for
+// "Comments[*].NickName". Expressions which aren't paths give one value.
+func (path *Expression) ExecuteAll(target interface{}) ([]*Value, error) {
+	return path.ExecuteAllEnv(nil, target)
+}
 
+// ExecuteAllEnv is ExecuteAll in env.
+func (path *Expression) ExecuteAllEnv(env *Env, target interface{}) ([]*Value, error) {
+	prog, err := Compile(string(*path))
 	if err != nil {
 		return nil, err
 	}
-
-	return value, nil
-
+	return prog.EvalAllEnv(env, target)
 }
 
 func (p Expression) FirstPart() string {
@@ -36,3 +46,13 @@ func (p Expression) FirstPart() string {
 	}
 	return upperFirst(string(p)[:idx])
 }
+
+// upperFirst turns the first letter of s to upper case, so lower camel
+// case paths (as found in JSON) can address exported fields and methods.
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
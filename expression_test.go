@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"strconv"
 
-	el "github.com/runcom/go-el"
+	el "github.com/runcom/el"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,6 +30,10 @@ func (t User) LocateImage(i int) *Image {
 	return t.ImgIdx[strconv.Itoa(i)]
 }
 
+func (t User) CountOf(v *el.Value) int {
+	return v.Len()
+}
+
 func TestLocate(t *testing.T) {
 
 	data := User{
@@ -137,6 +141,23 @@ func TestIndexAccess(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "しゃしん233.jpg", user.ImgIdx["0"].Content)
 
+	// Keys are converted to the key type of the map, when they fit in it
+	byID := map[uint8]string{7: "seven"}
+	exp = el.Expression("@[7]")
+	v, err = exp.Execute(byID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "seven", v.Interface())
+	}
+	for _, e := range []string{`@[300]`, `@["7"]`, `@[nil]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(byID)
+		assert.Error(t, err, e)
+	}
+	for _, e := range []string{`ImgIdx[1.5]`, `ImgIdx[true]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(&user)
+		assert.Error(t, err, e)
+	}
 }
 
 func TestIndexSet(t *testing.T) {
@@ -165,3 +186,716 @@ func TestIndexSet(t *testing.T) {
 	v.SetValue(99)
 	assert.Equal(t, 99, user.ImgIDList[99])
 }
+
+func TestExecuteEndToEnd(t *testing.T) {
+	b := &Blog{
+		Title:      "Blog title1",
+		CommentIds: []uint64{1, 3},
+		Comments: map[string]*Comment{
+			"1": {NickName: "u1"},
+			"3": {NickName: "tester"},
+		},
+	}
+
+	exp := el.Expression("Comments[CommentIds[0]].NickName")
+	v, err := exp.Execute(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "u1", v.String())
+
+	exp = el.Expression(`Comments["3"].NickName`)
+	v, err = exp.Execute(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "tester", v.String())
+
+	exp = el.Expression("Title Comments")
+	_, err = exp.Execute(b)
+	assert.Error(t, err)
+
+	exp = el.Expression("Comments[CommentIds[0]")
+	_, err = exp.Execute(b)
+	assert.Error(t, err)
+}
+
+func TestCompositeLiterals(t *testing.T) {
+	user := User{
+		Name:      "ほん",
+		ImgIDList: []int{0, 1, 2},
+	}
+
+	exp := el.Expression(`[1, 2, 3]`)
+	v, err := exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, 2, 3}, v.Interface())
+
+	exp = el.Expression(`["a", "b",]`)
+	v, err = exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b"}, v.Interface())
+
+	exp = el.Expression(`[]`)
+	v, err = exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{}, v.Interface())
+
+	exp = el.Expression(`{"k": Name, n: 3, 1: [ImgIDList[1]]}`)
+	v, err = exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"k": "ほん", "n": 3, "1": []interface{}{1}}, v.Interface())
+
+	exp = el.Expression(`Name in ["ほん", "pending"] && 3 not in [1, 2]`)
+	v, err = exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.True(t, v.Bool())
+
+	exp = el.Expression(`CountOf([1, 2, 3]) + CountOf({"a": 1})`)
+	v, err = exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, v.Interface())
+
+	for _, e := range []string{`[1 2]`, `{"a" 1}`, `{"a": 1 "b": 2}`, `{[1]: 2}`} {
+		exp = el.Expression(e)
+		_, err = exp.Execute(&user)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestSetCompositeValue(t *testing.T) {
+	user := User{
+		BizState: map[string]int{},
+	}
+
+	exp := el.Expression(`[3, 4]`)
+	v, err := exp.Execute(nil)
+	assert.NoError(t, err)
+	exp = el.Expression(`ImgIDList`)
+	list, err := exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.NoError(t, list.SetValue(v))
+	assert.Equal(t, []int{3, 4}, user.ImgIDList)
+
+	exp = el.Expression(`{"a": 1, "b": 2}`)
+	v, err = exp.Execute(nil)
+	assert.NoError(t, err)
+	exp = el.Expression(`BizState`)
+	state, err := exp.Execute(&user)
+	assert.NoError(t, err)
+	assert.NoError(t, state.SetValue(v))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, user.BizState)
+
+	assert.Error(t, list.SetValue([]interface{}{1.5}))
+	assert.Error(t, list.SetValue([]interface{}{"a"}))
+}
+
+type Profile struct {
+	Avatar *Image
+	Tags   []string
+	Extra  map[string]*Image
+	Any    interface{}
+	Count  int
+}
+
+func (p Profile) FirstImage() *Image {
+	return p.Extra["0"]
+}
+
+func TestNil(t *testing.T) {
+	p := &Profile{
+		Avatar: &Image{"a.png"},
+		Tags:   []string{"x"},
+		Extra:  map[string]*Image{"1": {"1.png"}},
+		Any:    "any",
+	}
+
+	cases := []struct {
+		exp      string
+		expected bool
+	}{
+		{`Avatar != nil`, true},
+		{`Avatar == null`, false},
+		{`FirstImage() == nil`, true},
+		{`Extra["1"] != nil`, true},
+		{`Extra["2"] == nil`, true},
+		{`Any != nil`, true},
+		{`Any == "any"`, true},
+		{`Count == nil`, false},
+		{`nil == null`, true},
+		{`!nil`, true},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(p)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	patcher := el.Patcher{}
+	err := patcher.PatchIt(p, el.Patch{
+		"avatar":    nil,
+		"tags":      nil,
+		"any":       nil,
+		"extra[1]":  nil,
+		"extra[99]": nil,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, p.Avatar)
+	assert.Nil(t, p.Tags)
+	assert.Nil(t, p.Any)
+	assert.Empty(t, p.Extra)
+	assert.NotNil(t, p.Extra)
+
+	exp := el.Expression(`Tags == nil && Avatar == nil && Any == nil`)
+	v, err := exp.Execute(p)
+	assert.NoError(t, err)
+	assert.True(t, v.Bool())
+
+	err = patcher.PatchIt(p, el.Patch{
+		"extra": nil,
+		"any":   3,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, p.Extra)
+	assert.Equal(t, 3, p.Any)
+
+	// A nil pointer is allocated when what it points to is patched
+	err = patcher.PatchIt(p, el.Patch{"avatar": Image{"b.png"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "b.png", p.Avatar.Content)
+
+	err = patcher.PatchIt(p, el.Patch{"count": nil})
+	assert.Error(t, err)
+}
+
+func TestUnicodeAndEscapes(t *testing.T) {
+	data := map[string]interface{}{
+		"写真":    "しゃしん.jpg",
+		"quote": `a"b`,
+	}
+
+	exp := el.Expression(`写真 == "しゃしん.jpg" && quote == "a\"b" && "あ" == 'あ'`)
+	v, err := exp.Execute(data)
+	assert.NoError(t, err)
+	assert.True(t, v.Bool())
+
+	exp = el.Expression(`"ほん" == 写真 ==`)
+	_, err = exp.Execute(data)
+	if assert.Error(t, err) {
+		assert.Equal(t, 14, err.(*el.Error).Cursor)
+	}
+}
+
+type Gallery struct {
+	Title  string
+	Images []*Image
+	Grid   [][]int
+	Fixed  [3]int
+}
+
+func (g Gallery) Pick(i int) []*Image {
+	return g.Images[i:]
+}
+
+func TestSliceAccess(t *testing.T) {
+	g := &Gallery{
+		Title:  "しゃしん gallery",
+		Images: []*Image{{"1.jpg"}, {"2.jpg"}, {"3.jpg"}},
+		Grid:   [][]int{{1, 2}, {3, 4}},
+		Fixed:  [3]int{7, 8, 9},
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`Images[1:][0].Content`, "2.jpg"},
+		{`Images[:1][0].Content`, "1.jpg"},
+		{`Images[1:2][0].Content`, "2.jpg"},
+		{`Images[:]`, g.Images},
+		{`Title[:4]`, "しゃしん"},
+		{`Title[5:]`, "gallery"},
+		{`Title[0:0]`, ""},
+		{`Fixed[1:]`, []int{8, 9}},
+		{`Grid[1][0]`, 3},
+		{`Grid[0][1:]`, []int{2}},
+		{`Pick(1)[1].Content`, "3.jpg"},
+		{`Images[Grid[0][0]:Grid[0][1] + 1][1].Content`, "3.jpg"},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(g)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	// Sliced elements still point into the original data
+	exp := el.Expression(`Images[1:][0].Content`)
+	v, err := exp.Execute(g)
+	assert.NoError(t, err)
+	assert.NoError(t, v.SetValue("changed.jpg"))
+	assert.Equal(t, "changed.jpg", g.Images[1].Content)
+
+	exp = el.Expression(`Grid[1][1]`)
+	v, err = exp.Execute(g)
+	assert.NoError(t, err)
+	assert.NoError(t, v.SetValue(40))
+	assert.Equal(t, [][]int{{1, 2}, {3, 40}}, g.Grid)
+
+	for _, e := range []string{`Images[2:1]`, `Images[:4]`, `Title[:20]`, `Images["a":]`, `Images[1:2:3]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(g)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestNegativeIndex(t *testing.T) {
+	g := &Gallery{
+		Title:  "しゃしん",
+		Images: []*Image{{"1.jpg"}, {"2.jpg"}, {"3.jpg"}},
+		Grid:   [][]int{{1, 2}, {3, 4}},
+		Fixed:  [3]int{7, 8, 9},
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`Images[-1].Content`, "3.jpg"},
+		{`Images[-3].Content`, "1.jpg"},
+		{`Title[-1]`, "ん"},
+		{`Title[0]`, "し"},
+		{`Fixed[-2]`, 8},
+		{`Grid[-1][-2]`, 3},
+		{`Images[-2:][0].Content`, "2.jpg"},
+		{`Title[:-1]`, "しゃし"},
+		{`Pick(1)[-1].Content`, "3.jpg"},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(g)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	exp := el.Expression(`Images[-1]`)
+	v, err := exp.Execute(g)
+	assert.NoError(t, err)
+	assert.NoError(t, v.SetValue(&Image{"last.jpg"}))
+	assert.Equal(t, "last.jpg", g.Images[2].Content)
+
+	exp = el.Expression(`Grid[-1]`)
+	v, err = exp.Execute(g)
+	assert.NoError(t, err)
+	assert.NoError(t, v.SetValue([]int{5}))
+	assert.Equal(t, [][]int{{1, 2}, {5}}, g.Grid)
+
+	exp = el.Expression(`Fixed[-1]`)
+	v, err = exp.Execute(g)
+	assert.NoError(t, err)
+	assert.NoError(t, v.SetValue(json.Number("10")))
+	assert.Equal(t, [3]int{7, 8, 10}, g.Fixed)
+
+	for _, e := range []string{`Images[-4]`, `Title[-5]`, `Title[4]`, `Fixed[3]`, `Pick(0)[5]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(g)
+		assert.Error(t, err, e)
+	}
+
+	// Indexes must be integers, not anything Integer() makes one of
+	for _, e := range []string{`Images[1.5]`, `Images["a"]`, `Title[true]`, `Fixed[nil]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(g)
+		if assert.Error(t, err, e) {
+			assert.Contains(t, err.Error(), "must be an integer", e)
+		}
+	}
+}
+
+type Author struct {
+	Name    string
+	Profile *Profile
+}
+
+type Post struct {
+	Nickname interface{}
+	Author   *Author
+	Images   []*Image
+}
+
+func (p Post) Cover() *Image {
+	if len(p.Images) == 0 {
+		return nil
+	}
+	return p.Images[0]
+}
+
+func TestSafeNavigation(t *testing.T) {
+	anonymous := &Post{}
+	post := &Post{
+		Author: &Author{
+			Name:    "alice",
+			Profile: &Profile{Avatar: &Image{"a.png"}, Tags: []string{"go"}},
+		},
+		Images: []*Image{{"1.jpg"}, nil},
+	}
+
+	cases := []struct {
+		target   *Post
+		exp      string
+		expected interface{}
+	}{
+		{anonymous, `Author?.Profile?.Avatar == nil`, true},
+		{anonymous, `Author?.Name ?? "guest"`, "guest"},
+		{anonymous, `Nickname ?? Author?.Name ?? "guest"`, "guest"},
+		{anonymous, `Cover()?.Content ?? "none"`, "none"},
+		{anonymous, `Author?.Profile?.Tags?.[0] ?? "none"`, "none"},
+		{post, `Author?.Profile?.Avatar?.Content`, "a.png"},
+		{post, `Author?.Name ?? "guest"`, "alice"},
+		{post, `Nickname ?? Author.Name ?? "guest"`, "alice"},
+		{post, `Author?.Profile?.Tags?.[0]`, "go"},
+		{post, `Images[1]?.Content ?? Images[0].Content`, "1.jpg"},
+		{post, `Images[Author?.Profile?.Count ?? 1].Content`, "1.jpg"},
+		{post, `Cover()?.Content`, "1.jpg"},
+		{post, `nil ?? false`, false},
+		{post, `0 ?? 1`, 0},
+		{post, `"" ?? "empty"`, ""},
+		{post, `nil ?? nil`, nil},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(c.target)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	// Without "?." going on from nil is an error, while "?." skips the
+	// rest of the path
+	for _, e := range []string{`Author.Name`, `Cover().Content`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(anonymous)
+		assert.Error(t, err, e)
+	}
+	exp := el.Expression(`Author?.Profile.Avatar.Content`)
+	v, err := exp.Execute(anonymous)
+	assert.NoError(t, err)
+	assert.Nil(t, v.Interface())
+	exp = el.Expression(`Images[1].Content`)
+	_, err = exp.Execute(post)
+	assert.Error(t, err)
+
+	for _, e := range []string{`Author?.`, `Author?.(1)`, `Author ?. Name ??`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(post)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	g := &Gallery{
+		Title:  "g",
+		Images: []*Image{{"1.jpg"}, {"2.jpg"}, nil},
+		Grid:   [][]int{{1, 2}, {3, 4}},
+		Fixed:  [3]int{7, 8, 9},
+	}
+
+	exp := el.Expression(`Images[*]?.Content`)
+	values, err := exp.ExecuteAll(g)
+	if assert.NoError(t, err) && assert.Len(t, values, 3) {
+		assert.Equal(t, "1.jpg", values[0].Interface())
+		assert.Equal(t, "2.jpg", values[1].Interface())
+		assert.Nil(t, values[2].Interface())
+	}
+
+	exp = el.Expression(`Grid[*][*]`)
+	values, err = exp.ExecuteAll(g)
+	if assert.NoError(t, err) && assert.Len(t, values, 4) {
+		for i, v := range values {
+			assert.Equal(t, i+1, v.Interface())
+			assert.NoError(t, v.SetValue(json.Number(strconv.Itoa(10*(i+1)))))
+		}
+	}
+	assert.Equal(t, [][]int{{10, 20}, {30, 40}}, g.Grid)
+
+	exp = el.Expression(`Fixed[*]`)
+	values, err = exp.ExecuteAll(g)
+	if assert.NoError(t, err) && assert.Len(t, values, 3) {
+		assert.NoError(t, values[1].SetValue(0))
+	}
+	assert.Equal(t, [3]int{7, 0, 9}, g.Fixed)
+
+	// A single value for paths without a wildcard, a list within expressions
+	exp = el.Expression(`Title`)
+	values, err = exp.ExecuteAll(g)
+	if assert.NoError(t, err) && assert.Len(t, values, 1) {
+		assert.Equal(t, "g", values[0].Interface())
+	}
+	exp = el.Expression(`"2.jpg" in Images[0:2][*].Content`)
+	v, err := exp.Execute(g)
+	if assert.NoError(t, err) {
+		assert.Equal(t, true, v.Interface())
+	}
+	exp = el.Expression(`Pick(1)[*]?.Content`)
+	v, err = exp.Execute(g)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{"2.jpg", nil}, v.Interface())
+	}
+
+	u := &User{BizState: map[string]int{"b": 2, "a": 1, "c": 3}}
+	exp = el.Expression(`BizState[*]`)
+	values, err = exp.ExecuteAll(u)
+	if assert.NoError(t, err) && assert.Len(t, values, 3) {
+		for i, v := range values {
+			assert.Equal(t, i+1, v.Interface())
+		}
+		assert.NoError(t, values[1].SetValue(20))
+	}
+	assert.Equal(t, 20, u.BizState["b"])
+
+	for _, e := range []string{`Title[*]`, `Images[*].Content`, `Missing[*]`} {
+		exp := el.Expression(e)
+		_, err := exp.ExecuteAll(g)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	g := &Gallery{
+		Title:  "g",
+		Images: []*Image{{"1.jpg"}, {"2.png"}, nil, {"3.png"}},
+		Grid:   [][]int{{1, 2}, {3, 4}},
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`Images[?(Content matches ".png$")].Content`, []interface{}{"2.png", "3.png"}},
+		{`Images[?(@.Content == "1.jpg")].Content`, []interface{}{"1.jpg"}},
+		{`Images[?(Content == "x")].Content`, []interface{}{}},
+		{`Grid[*][?(@ % 2 == 0)]`, []interface{}{2, 4}},
+		{`Grid[?(@[0] > 1)][1]`, []interface{}{4}},
+		{`"3.png" in Images[?(Content != "1.jpg")].Content`, true},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(g)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	u := &User{BizState: map[string]int{"a": 1, "b": 2, "c": 3}}
+	exp := el.Expression(`BizState[?(@ >= 2)]`)
+	values, err := exp.ExecuteAll(u)
+	if assert.NoError(t, err) && assert.Len(t, values, 2) {
+		assert.Equal(t, 2, values[0].Interface())
+		assert.Equal(t, 3, values[1].Interface())
+	}
+
+	patcher := el.Patcher{}
+	err = patcher.PatchIt(g, el.Patch{
+		`images[?(content matches ".png$")].content`: "x.gif",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.jpg", g.Images[0].Content)
+	assert.Equal(t, "x.gif", g.Images[1].Content)
+	assert.Equal(t, "x.gif", g.Images[3].Content)
+
+	for _, e := range []string{`Images[?Content]`, `Images[?(Content]`, `Images[?(Missing.Content)]`, `Title[?(@)]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(g)
+		assert.Error(t, err, e)
+	}
+}
+
+type Reply struct {
+	ID      uint64
+	Content string
+}
+
+type Thread struct {
+	Replies []*Reply
+	Drafts  []Reply
+	ByKey   map[string]map[string]interface{}
+}
+
+func TestKeySelection(t *testing.T) {
+	th := &Thread{
+		Replies: []*Reply{{1, "a"}, nil, {3, "c"}},
+		Drafts:  []Reply{{7, "d"}},
+		ByKey: map[string]map[string]interface{}{
+			"x": {"name": "ex"},
+			"y": {"name": "why"},
+		},
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`Replies[ID=3].Content`, "c"},
+		{`Replies[ID=1+2].Content`, "c"},
+		{`Replies[Content="a"].ID`, uint64(1)},
+		{`Replies[ID=Drafts[0].ID - 6].Content`, "a"},
+		{`Replies[ID=9]`, nil},
+		{`Replies[ID=9]?.Content ?? "none"`, "none"},
+		{`Drafts[ID=7].Content`, "d"},
+		{`ByKey[name="why"]["name"]`, "why"},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(th)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	patcher := el.Patcher{}
+	err := patcher.PatchIt(th, el.Patch{
+		"replies[ID=3].content": "changed",
+		"replies[ID=1]":         &Reply{1, "replaced"},
+		"drafts[ID=7].content":  "draft",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", th.Replies[2].Content)
+	assert.Equal(t, "replaced", th.Replies[0].Content)
+	assert.Equal(t, "draft", th.Drafts[0].Content)
+
+	for _, e := range []string{`Replies[ID=9].Content`, `Replies[ID=]`, `Replies[ID=1`, `Replies[ID=1=2]`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(th)
+		assert.Error(t, err, e)
+	}
+	err = patcher.PatchIt(th, el.Patch{"replies[ID=9]": &Reply{}})
+	assert.Error(t, err)
+}
+
+type Node struct {
+	Name      string
+	UpdatedAt int
+	Parent    *Node
+	Children  []*Node
+	Meta      map[string]interface{}
+}
+
+func TestRecursiveDescent(t *testing.T) {
+	root := &Node{Name: "root", UpdatedAt: 1}
+	child := &Node{Name: "child", UpdatedAt: 2, Parent: root, Meta: map[string]interface{}{
+		"updatedAt": 3,
+		"nested":    map[string]interface{}{"Name": "meta"},
+	}}
+	root.Children = []*Node{child, child}
+	root.Parent = root
+
+	// The child shared by both items is found under each of them, the
+	// parents pointing back up aren't followed
+	exp := el.Expression(`..UpdatedAt`)
+	values, err := exp.ExecuteAll(root)
+	if assert.NoError(t, err) && assert.Len(t, values, 3) {
+		assert.Equal(t, 1, values[0].Interface())
+		assert.Equal(t, "UpdatedAt", values[0].Path())
+		assert.Equal(t, 2, values[1].Interface())
+		assert.Equal(t, "Children[0].UpdatedAt", values[1].Path())
+		assert.Equal(t, 2, values[2].Interface())
+		assert.Equal(t, "Children[1].UpdatedAt", values[2].Path())
+	}
+
+	exp = el.Expression(`Children[0]..Name`)
+	values, err = exp.ExecuteAll(root)
+	if assert.NoError(t, err) && assert.Len(t, values, 3) {
+		paths := []string{}
+		for _, v := range values {
+			paths = append(paths, v.Path())
+		}
+		assert.Equal(t, []string{
+			"Children[0].Name",
+			"Children[0].Parent.Name",
+			`Children[0].Meta["nested"]["Name"]`,
+		}, paths)
+		assert.Equal(t, "meta", values[2].Interface())
+	}
+
+	exp = el.Expression(`Children[1].Meta..updatedAt`)
+	values, err = exp.ExecuteAll(root)
+	if assert.NoError(t, err) && assert.Len(t, values, 1) {
+		assert.Equal(t, 3, values[0].Interface())
+		assert.Equal(t, `Children[1].Meta["updatedAt"]`, values[0].Path())
+	}
+
+	// Concrete paths can be executed again
+	exp = el.Expression(`Meta..Name`)
+	values, err = exp.ExecuteAll(child)
+	if assert.NoError(t, err) && assert.Len(t, values, 1) {
+		exp = el.Expression(values[0].Path())
+		v, err := exp.Execute(child)
+		assert.NoError(t, err)
+		assert.Equal(t, "meta", v.Interface())
+	}
+
+	exp = el.Expression(`..Missing`)
+	values, err = exp.ExecuteAll(root)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	patcher := el.Patcher{}
+	err = patcher.PatchIt(root, el.Patch{"..updatedAt": 0})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, root.UpdatedAt)
+	assert.Equal(t, 0, child.UpdatedAt)
+	assert.Equal(t, 0, child.Meta["updatedAt"])
+
+	for _, e := range []string{`..`, `..[0]`, `Name..`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(root)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestRecursiveDescentSharedData(t *testing.T) {
+	// Each node points twice to the next one, there are 2^39 paths to the
+	// last one
+	nodes := make([]*Node, 40)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodes[i] = &Node{Name: "n" + strconv.Itoa(i), UpdatedAt: i}
+		if i < len(nodes)-1 {
+			nodes[i].Children = []*Node{nodes[i+1], nodes[i+1]}
+		}
+	}
+
+	exp := el.Expression(`..UpdatedAt`)
+	values, err := exp.ExecuteAll(nodes[0])
+	if assert.NoError(t, err) && assert.Len(t, values, 1+2*39) {
+		assert.Equal(t, "UpdatedAt", values[0].Path())
+		assert.Equal(t, "Children[0].UpdatedAt", values[1].Path())
+		assert.Equal(t, "Children[0].Children[0].UpdatedAt", values[2].Path())
+		// The last node is found under both fields of the node before it
+		assert.Equal(t, 39, values[39].Interface())
+		assert.Equal(t, 39, values[40].Interface())
+		assert.Equal(t, 38, values[41].Interface())
+		assert.Equal(t, "Children[1].UpdatedAt", values[len(values)-1].Path())
+	}
+
+	// Every node points to every node, itself included
+	peers := make([]*Node, 30)
+	for i := range peers {
+		peers[i] = &Node{Name: "p" + strconv.Itoa(i)}
+	}
+	for _, p := range peers {
+		p.Children = peers
+	}
+
+	// A node is walked from the first one pointing to it, and found again
+	// under the ones walked after it
+	exp = el.Expression(`..Name`)
+	values, err = exp.ExecuteAll(peers[0])
+	if assert.NoError(t, err) && assert.Len(t, values, 30+28*29/2) {
+		names := map[interface{}]bool{}
+		for _, v := range values {
+			names[v.Interface()] = true
+		}
+		assert.Len(t, names, 30)
+		assert.Equal(t, "Children[1].Children[2].Name", values[2].Path())
+	}
+}
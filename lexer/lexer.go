@@ -3,7 +3,10 @@ package lexer
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/alediaferia/stackgo"
 	"github.com/pkg/errors"
@@ -11,16 +14,98 @@ import (
 )
 
 var (
-	numbersRegexp = regexp.MustCompile(`\A([0-9]+(?:\.[0-9]+)?)`)
-	stringsRegexp = regexp.MustCompile(`\A("([^"\\\\]*(?:\\\\.[^"\\\\]*)*)"|\A'([^'\\\\]*(?:\\\\.[^'\\\\]*)*)')`)
-	// FIXME: golang doesn't support Perl's (?=) see https://github.com/google/re2/wiki/Syntax
+	// hex (0xFF), decimal (1_000, 9.99) and exponent (1e6, 2.5E-3) numbers
+	numbersRegexp = regexp.MustCompile(`\A(0[xX][0-9a-fA-F]+(?:_[0-9a-fA-F]+)*|[0-9]+(?:_[0-9]+)*(?:\.[0-9]+(?:_[0-9]+)*)?(?:[eE][+-]?[0-9]+)?)`)
+	// double or single quoted strings, with backslash escapes
+	stringsRegexp = regexp.MustCompile(`\A(?s:"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')`)
+	// golang doesn't support Perl's (?=) see https://github.com/google/re2/wiki/Syntax
+	// so word operators ("in", "not in"...) are not part of operatorsRegexp,
+	// they are recognised once a whole name has been matched, see wordOperator
 	//operatorsRegexp = regexp.MustCompile(`\Anot in(?=[\s(])|\!\=\=|not(?=[\s(])|and(?=[\s(])|\=\=\=|\>\=|or(?=[\s(])|\<\=|\*\*|\.\.|in(?=[\s(])|&&|\|\||matches|\=\=|\!\=|\*|~|%|\/|\>|\||\!|\^|&|\+|\<|\-`)
-	operatorsRegexp = regexp.MustCompile(`\A(\!\=|\=\=|\>\=|\<\=|&&|\|\||\*|\/|\>|\||\!|\+|\<|\-)`)
-	namesRegexp     = regexp.MustCompile(`\A([a-zA-Z_\x7f-\xff][a-zA-Z0-9_\x7f-\xff]*)`)
+	operatorsRegexp = regexp.MustCompile(`\A(\?\?|\!\=|\=\=|\>\=|\<\=|\<\<|\>\>|\*\*|&&|\|\||\*|\/|%|\>|\||\^|&|\!|\+|\<|\-|\=)`)
+	// "@" names the current element (e.g. in filters) and "$name" a variable
+	namesRegexp = regexp.MustCompile(`\A(@|\$?[\p{L}_][\p{L}\p{Nd}_]*)`)
+
+	// keywords are names with a fixed meaning, they are emitted as
+	// token.TypeKeyword instead of token.TypeName
+	keywords = map[string]bool{
+		"true":  true,
+		"false": true,
+		"nil":   true,
+		"null":  true,
+	}
+
+	// wordOperators are operators spelled as a single name
+	wordOperators = map[string]bool{
+		"in":      true,
+		"matches": true,
+		"and":     true,
+		"or":      true,
+		"not":     true,
+	}
+	// multiWordOperators maps the first word of an operator spelled as
+	// several names to the words which may follow it
+	multiWordOperators = map[string][]string{
+		"not": {"in"},
+	}
 )
 
+// wordOperator reports whether expr starts with an operator spelled as
+// names, returning the operator (with single spaces between its words) and
+// the number of bytes it spans. Names only match whole, so "index" or
+// "notes" never start an operator.
+func wordOperator(expr string) (string, int) {
+	m := namesRegexp.FindStringIndex(expr)
+	if len(m) == 0 {
+		return "", 0
+	}
+	word := expr[:m[1]]
+	for _, next := range multiWordOperators[word] {
+		rest := expr[m[1]:]
+		trimmed := strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if len(trimmed) == len(rest) {
+			continue
+		}
+		if namesRegexp.FindString(trimmed) == next {
+			return word + " " + next, len(expr) - len(trimmed) + len(next)
+		}
+	}
+	if wordOperators[word] {
+		return word, m[1]
+	}
+	return "", 0
+}
+
+// unescape decodes the Go escape sequences (\n, \t, \uXXXX...) of a string
+// literal body. Both \" and \' are accepted whatever the quotes are.
+func unescape(s string) (string, error) {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s, nil
+	}
+	var b strings.Builder
+	for len(s) > 0 {
+		if len(s) > 1 && s[0] == '\\' && (s[1] == '"' || s[1] == '\'') {
+			b.WriteByte(s[1])
+			s = s[2:]
+			continue
+		}
+		r, _, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(r)
+		s = tail
+	}
+	return b.String(), nil
+}
+
+// Tokenize splits expression into tokens. Token cursors are 1-based and
+// counted in runes.
 func Tokenize(expression string) (*token.TokenStream, error) {
-	expression = strings.Replace(expression, "\r\t\v\f\n", " ", -1)
+	// pos turns a byte offset into a 1-based rune position
+	pos := func(offset int) int {
+		return utf8.RuneCountInString(expression[:offset]) + 1
+	}
 	var (
 		cursor   int
 		tokens   = []token.Token{}
@@ -33,30 +118,30 @@ func Tokenize(expression string) (*token.TokenStream, error) {
 	}
 
 	for cursor < end {
-		if expression[cursor] == ' ' {
-			cursor++
+		if r, size := utf8.DecodeRuneInString(expression[cursor:]); unicode.IsSpace(r) {
+			cursor += size
 			continue
 		}
 		if m := numbersRegexp.FindStringIndex(expression[cursor:]); len(m) != 0 {
 			t := token.Token{
 				Value:  expression[cursor+m[0] : cursor+m[1]],
 				Type:   token.TypeNumber,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
 			}
 			tokens = append(tokens, t)
 			cursor = cursor + (m[1] - m[0])
 		} else if expression[cursor] == '(' || expression[cursor] == '[' || expression[cursor] == '{' {
 			brackets.Push(bracket{char: expression[cursor], cursor: cursor})
 			t := token.Token{
-				Value:  expression[cursor],
+				Value:  string(expression[cursor]),
 				Type:   token.TypePunctuation,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
 			}
 			tokens = append(tokens, t)
 			cursor++
 		} else if expression[cursor] == ')' || expression[cursor] == ']' || expression[cursor] == '}' {
 			if brackets.Size() == 0 {
-				return nil, fmt.Errorf("unexpected %c, %d", expression[cursor], cursor)
+				return nil, fmt.Errorf("unexpected %c, %d", expression[cursor], pos(cursor))
 			}
 			b := brackets.Pop()
 			br, ok := b.(bracket)
@@ -73,21 +158,25 @@ func Tokenize(expression string) (*token.TokenStream, error) {
 				closingBracket = '}'
 			}
 			if expression[cursor] != closingBracket {
-				return nil, fmt.Errorf("unclosed %c, %d", br.char, br.cursor)
+				return nil, fmt.Errorf("unclosed %c, %d", br.char, pos(br.cursor))
 			}
 			t := token.Token{
-				Value:  expression[cursor],
+				Value:  string(expression[cursor]),
 				Type:   token.TypePunctuation,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
 			}
 			tokens = append(tokens, t)
 			cursor++
 		} else if m := stringsRegexp.FindStringIndex(expression[cursor:]); len(m) != 0 {
 			quotedStr := expression[cursor+m[0] : cursor+m[1]]
+			str, err := unescape(quotedStr[1 : len(quotedStr)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid escape in string, %d", pos(cursor))
+			}
 			t := token.Token{
-				Value:  quotedStr[1 : len(quotedStr)-1],
+				Value:  str,
 				Type:   token.TypeString,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
 			}
 			tokens = append(tokens, t)
 			cursor = cursor + (m[1] - m[0])
@@ -95,34 +184,55 @@ func Tokenize(expression string) (*token.TokenStream, error) {
 			t := token.Token{
 				Value:  expression[cursor+m[0] : cursor+m[1]],
 				Type:   token.TypeOperator,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
 			}
 			tokens = append(tokens, t)
 			cursor = cursor + (m[1] - m[0])
+		} else if strings.HasPrefix(expression[cursor:], "?.") {
+			t := token.Token{
+				Value:  "?.",
+				Type:   token.TypePunctuation,
+				Cursor: pos(cursor),
+			}
+			tokens = append(tokens, t)
+			cursor += 2
 		} else if expression[cursor] == '.' || expression[cursor] == ',' || expression[cursor] == '?' || expression[cursor] == ':' {
 			t := token.Token{
-				Value:  expression[cursor],
+				Value:  string(expression[cursor]),
 				Type:   token.TypePunctuation,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
 			}
 			tokens = append(tokens, t)
 			cursor++
+		} else if op, n := wordOperator(expression[cursor:]); n != 0 {
+			t := token.Token{
+				Value:  op,
+				Type:   token.TypeOperator,
+				Cursor: pos(cursor),
+			}
+			tokens = append(tokens, t)
+			cursor = cursor + n
 		} else if m := namesRegexp.FindStringIndex(expression[cursor:]); len(m) != 0 {
+			name := expression[cursor+m[0] : cursor+m[1]]
 			t := token.Token{
-				Value:  expression[cursor+m[0] : cursor+m[1]],
+				Value:  name,
 				Type:   token.TypeName,
-				Cursor: cursor + 1,
+				Cursor: pos(cursor),
+			}
+			if keywords[name] {
+				t.Type = token.TypeKeyword
 			}
 			tokens = append(tokens, t)
 			cursor = cursor + (m[1] - m[0])
 		} else {
-			return nil, fmt.Errorf("unlexable %c, %d", expression[cursor], cursor)
+			r, _ := utf8.DecodeRuneInString(expression[cursor:])
+			return nil, fmt.Errorf("unlexable %c, %d", r, pos(cursor))
 		}
 	}
 
 	t := token.Token{
 		Type:   token.TypeEOF,
-		Cursor: cursor + 1,
+		Cursor: pos(cursor),
 	}
 	tokens = append(tokens, t)
 
@@ -132,7 +242,7 @@ func Tokenize(expression string) (*token.TokenStream, error) {
 		if !ok {
 			return nil, errors.Wrap(fmt.Errorf("internal error"), "lexer")
 		}
-		return nil, fmt.Errorf("unexpected %c, %d", br.char, br.cursor)
+		return nil, fmt.Errorf("unexpected %c, %d", br.char, pos(br.cursor))
 	}
 	return token.NewTokenStream(tokens), nil
 }
@@ -26,3 +26,186 @@ func TestTokenize(t *testing.T) {
 	}
 	//fmt.Println(ts)
 }
+
+func TestTokenizeKinds(t *testing.T) {
+	ts, err := lexer.Tokenize(`Comments[CommentIds[0]].NickName == true`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []token.Token{
+		{Value: "Comments", Type: token.TypeName, Cursor: 1},
+		{Value: "[", Type: token.TypePunctuation, Cursor: 9},
+		{Value: "CommentIds", Type: token.TypeName, Cursor: 10},
+		{Value: "[", Type: token.TypePunctuation, Cursor: 20},
+		{Value: "0", Type: token.TypeNumber, Cursor: 21},
+		{Value: "]", Type: token.TypePunctuation, Cursor: 22},
+		{Value: "]", Type: token.TypePunctuation, Cursor: 23},
+		{Value: ".", Type: token.TypePunctuation, Cursor: 24},
+		{Value: "NickName", Type: token.TypeName, Cursor: 25},
+		{Value: "==", Type: token.TypeOperator, Cursor: 34},
+		{Value: "true", Type: token.TypeKeyword, Cursor: 37},
+		{Type: token.TypeEOF, Cursor: 41},
+	}
+	if ts.Size() != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), ts.Size())
+	}
+	for i, tok := range expected {
+		if ts.Current != tok {
+			t.Fatalf("token %d: expected %+v, got %+v", i, tok, ts.Current)
+		}
+		ts.Next()
+	}
+	if !ts.EOF() {
+		t.Fatal("expected stream to stay at EOF")
+	}
+}
+
+func TestTokenizeWordOperators(t *testing.T) {
+	ts, err := lexer.Tokenize(`index in notes not   in inner not nothing and order or android`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []token.Token{
+		{Value: "index", Type: token.TypeName, Cursor: 1},
+		{Value: "in", Type: token.TypeOperator, Cursor: 7},
+		{Value: "notes", Type: token.TypeName, Cursor: 10},
+		{Value: "not in", Type: token.TypeOperator, Cursor: 16},
+		{Value: "inner", Type: token.TypeName, Cursor: 25},
+		{Value: "not", Type: token.TypeOperator, Cursor: 31},
+		{Value: "nothing", Type: token.TypeName, Cursor: 35},
+		{Value: "and", Type: token.TypeOperator, Cursor: 43},
+		{Value: "order", Type: token.TypeName, Cursor: 47},
+		{Value: "or", Type: token.TypeOperator, Cursor: 53},
+		{Value: "android", Type: token.TypeName, Cursor: 56},
+		{Type: token.TypeEOF, Cursor: 63},
+	}
+	for i, tok := range expected {
+		if ts.Current != tok {
+			t.Fatalf("token %d: expected %+v, got %+v", i, tok, ts.Current)
+		}
+		ts.Next()
+	}
+}
+
+func TestTokenizeNumbers(t *testing.T) {
+	for _, n := range []string{"0", "42", "9.99", "0xFF", "0Xa_b", "1e6", "2.5E-3", "1_000", "1_000.5e+2"} {
+		ts, err := lexer.Tokenize(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts.Size() != 2 || ts.Current.Type != token.TypeNumber || ts.Current.Value != n {
+			t.Fatalf("expected %s to be a single number, got %+v", n, ts.Tokens)
+		}
+	}
+}
+
+func TestTokenizeStrings(t *testing.T) {
+	cases := map[string]string{
+		`"a\"b"`:        `a"b`,
+		`'a\'b'`:        `a'b`,
+		`"it\'s"`:       `it's`,
+		`'say \"hi\"'`:  `say "hi"`,
+		`"\n\t"`:        "\n\t",
+		`"あ\x41"`:       "あA",
+		`"\\"`:          `\`,
+		`"しゃしん.jpg"`:    "しゃしん.jpg",
+		`'double " ok'`: `double " ok`,
+	}
+	for in, expected := range cases {
+		ts, err := lexer.Tokenize(in)
+		if err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if ts.Current.Type != token.TypeString || ts.Current.Value != expected {
+			t.Fatalf("%s: expected string %q, got %+v", in, expected, ts.Current)
+		}
+		if ts.Size() != 2 {
+			t.Fatalf("%s: expected a single string, got %+v", in, ts.Tokens)
+		}
+	}
+
+	for _, in := range []string{`"\q"`, `"\u30"`, `"unterminated`} {
+		if _, err := lexer.Tokenize(in); err == nil {
+			t.Fatalf("%s: expected an error", in)
+		}
+	}
+}
+
+func TestTokenizeUnicode(t *testing.T) {
+	ts, err := lexer.Tokenize("写真[\"ほん\"]\t==\n名前_2 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []token.Token{
+		{Value: "写真", Type: token.TypeName, Cursor: 1},
+		{Value: "[", Type: token.TypePunctuation, Cursor: 3},
+		{Value: "ほん", Type: token.TypeString, Cursor: 4},
+		{Value: "]", Type: token.TypePunctuation, Cursor: 8},
+		{Value: "==", Type: token.TypeOperator, Cursor: 10},
+		{Value: "名前_2", Type: token.TypeName, Cursor: 13},
+		{Type: token.TypeEOF, Cursor: 18},
+	}
+	for i, tok := range expected {
+		if ts.Current != tok {
+			t.Fatalf("token %d: expected %+v, got %+v", i, tok, ts.Current)
+		}
+		ts.Next()
+	}
+
+	_, err = lexer.Tokenize("ほん # 1")
+	if err == nil || err.Error() != "unlexable #, 4" {
+		t.Fatalf("expected unlexable error at rune 4, got %v", err)
+	}
+}
+
+func TestTokenizeSafeNavigation(t *testing.T) {
+	ts, err := lexer.Tokenize(`a?.b ?? c ? d : e`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []token.Token{
+		{Value: "a", Type: token.TypeName, Cursor: 1},
+		{Value: "?.", Type: token.TypePunctuation, Cursor: 2},
+		{Value: "b", Type: token.TypeName, Cursor: 4},
+		{Value: "??", Type: token.TypeOperator, Cursor: 6},
+		{Value: "c", Type: token.TypeName, Cursor: 9},
+		{Value: "?", Type: token.TypePunctuation, Cursor: 11},
+		{Value: "d", Type: token.TypeName, Cursor: 13},
+		{Value: ":", Type: token.TypePunctuation, Cursor: 15},
+		{Value: "e", Type: token.TypeName, Cursor: 17},
+		{Type: token.TypeEOF, Cursor: 18},
+	}
+	for i, tok := range expected {
+		if ts.Current != tok {
+			t.Fatalf("token %d: expected %+v, got %+v", i, tok, ts.Current)
+		}
+		ts.Next()
+	}
+}
+
+func TestTokenizeSpecialNames(t *testing.T) {
+	ts, err := lexer.Tokenize(`$user.ID == @.ID`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []token.Token{
+		{Value: "$user", Type: token.TypeName, Cursor: 1},
+		{Value: ".", Type: token.TypePunctuation, Cursor: 6},
+		{Value: "ID", Type: token.TypeName, Cursor: 7},
+		{Value: "==", Type: token.TypeOperator, Cursor: 10},
+		{Value: "@", Type: token.TypeName, Cursor: 13},
+		{Value: ".", Type: token.TypePunctuation, Cursor: 14},
+		{Value: "ID", Type: token.TypeName, Cursor: 15},
+		{Type: token.TypeEOF, Cursor: 17},
+	}
+	for i, tok := range expected {
+		if ts.Current != tok {
+			t.Fatalf("token %d: expected %+v, got %+v", i, tok, ts.Current)
+		}
+		ts.Next()
+	}
+
+	if _, err := lexer.Tokenize(`$ user`); err == nil {
+		t.Fatal("expected an error for a lone $")
+	}
+}
@@ -3,22 +3,31 @@ package el
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/runcom/el/token"
 )
 
 const (
 	varTypeInt = iota
 	varTypeIdent
+	// varTypeIndex parts have no name, they apply an index, slice or
+	// call to the current value, e.g. the second bracket of "a[0][1]"
+	varTypeIndex
+	// varTypeVar parts start a path from a variable of the environment,
+	// as in "$user.Name"
+	varTypeVar
 )
 
 type IEvaluator interface {
-	GetPositionToken() *Token
+	GetPositionToken() *token.Token
 	Evaluate(target interface{}) (*Value, *Error)
 }
 
 type intResolver struct {
-	locationToken *Token
+	locationToken *token.Token
 	val           int
 }
 
@@ -26,12 +35,25 @@ func (i *intResolver) Evaluate(target interface{}) (*Value, *Error) {
 	return AsValue(i.val), nil
 }
 
-func (i *intResolver) GetPositionToken() *Token {
+func (i *intResolver) GetPositionToken() *token.Token {
 	return i.locationToken
 }
 
+type floatResolver struct {
+	locationToken *token.Token
+	val           float64
+}
+
+func (f *floatResolver) Evaluate(target interface{}) (*Value, *Error) {
+	return AsValue(f.val), nil
+}
+
+func (f *floatResolver) GetPositionToken() *token.Token {
+	return f.locationToken
+}
+
 type stringResolver struct {
-	locationToken *Token
+	locationToken *token.Token
 	val           string
 }
 
@@ -39,12 +61,12 @@ func (s *stringResolver) Evaluate(target interface{}) (*Value, *Error) {
 	return AsValue(s.val), nil
 }
 
-func (s *stringResolver) GetPositionToken() *Token {
+func (s *stringResolver) GetPositionToken() *token.Token {
 	return s.locationToken
 }
 
 type boolResolver struct {
-	locationToken *Token
+	locationToken *token.Token
 	val           bool
 }
 
@@ -52,12 +74,69 @@ func (b *boolResolver) Evaluate(target interface{}) (*Value, *Error) {
 	return AsValue(b.val), nil
 }
 
-func (b *boolResolver) GetPositionToken() *Token {
+func (b *boolResolver) GetPositionToken() *token.Token {
 	return b.locationToken
 }
 
+type nilResolver struct {
+	locationToken *token.Token
+}
+
+func (n *nilResolver) Evaluate(target interface{}) (*Value, *Error) {
+	return AsValue(nil), nil
+}
+
+func (n *nilResolver) GetPositionToken() *token.Token {
+	return n.locationToken
+}
+
+type arrayResolver struct {
+	locationToken *token.Token
+	items         []IEvaluator
+}
+
+// Evaluate builds a []interface{} out of the evaluated items.
+func (a *arrayResolver) Evaluate(target interface{}) (*Value, *Error) {
+	items := make([]interface{}, 0, len(a.items))
+	for _, item := range a.items {
+		v, err := item.Evaluate(target)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v.Interface())
+	}
+	return AsValue(items), nil
+}
+
+func (a *arrayResolver) GetPositionToken() *token.Token {
+	return a.locationToken
+}
+
+type mapResolver struct {
+	locationToken *token.Token
+	keys          []string
+	values        []IEvaluator
+}
+
+// Evaluate builds a map[string]interface{} out of the evaluated entries.
+func (m *mapResolver) Evaluate(target interface{}) (*Value, *Error) {
+	entries := make(map[string]interface{}, len(m.keys))
+	for i, key := range m.keys {
+		v, err := m.values[i].Evaluate(target)
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = v.Interface()
+	}
+	return AsValue(entries), nil
+}
+
+func (m *mapResolver) GetPositionToken() *token.Token {
+	return m.locationToken
+}
+
 type variableResolver struct {
-	locationToken *Token
+	locationToken *token.Token
 
 	parts []*variablePart
 }
@@ -67,21 +146,67 @@ type functionCallArgument interface {
 }
 
 func (vr *variableResolver) Evaluate(target interface{}) (*Value, *Error) {
-	value, err := vr.resolve(target)
+	values, err := vr.EvaluateAll(target)
+	if err != nil {
+		return AsValue(nil), err
+	}
+	if !vr.fansOut() {
+		return values[0], nil
+	}
+	// A path fanning out evaluates to the list of the values it reached
+	items := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		items = append(items, v.Interface())
+	}
+	return AsValue(items), nil
+}
+
+// EvaluateAll returns every value reached by the path, each one with its
+// own setter. That is a single value unless a part fans out, as the
+// wildcard of "Comments[*].NickName" does.
+func (vr *variableResolver) EvaluateAll(target interface{}) ([]*Value, *Error) {
+	values, err := vr.resolve(target)
 	if err != nil {
-		return AsValue(nil), NewError(err.Error(), vr.locationToken)
+		return nil, NewError(err.Error(), vr.locationToken)
 	}
-	return value, nil
+	return values, nil
+}
+
+// fansOut reports whether the path may reach several values.
+func (vr *variableResolver) fansOut() bool {
+	for _, part := range vr.parts {
+		if part.fansOut() {
+			return true
+		}
+	}
+	return false
 }
 
 func (vr *variableResolver) String() string {
 	parts := make([]string, 0, len(vr.parts))
-	for _, p := range vr.parts {
+	for i, p := range vr.parts {
+		if p.isDeep {
+			if i == 0 {
+				parts = append(parts, ".."+p.s)
+			} else {
+				parts = append(parts, "."+p.s)
+			}
+			continue
+		}
 		switch p.typ {
 		case varTypeInt:
 			parts = append(parts, strconv.Itoa(p.i))
 		case varTypeIdent:
 			parts = append(parts, p.s)
+		case varTypeVar:
+			parts = append(parts, "$"+p.s)
+		case varTypeIndex:
+			if p.s != "" {
+				// "@"
+				parts = append(parts, p.s)
+			} else {
+				parts = append(parts, "[]")
+			}
 		default:
 			panic("unimplemented")
 		}
@@ -89,243 +214,778 @@ func (vr *variableResolver) String() string {
 	return strings.Join(parts, ".")
 }
 
-func (vr *variableResolver) resolve(target interface{}) (*Value, error) {
-
-	var keySetter *KeySetter
-	current := reflect.ValueOf(target)
+// location is a value reached while resolving a path, along with what
+// sets it.
+type location struct {
+	val       reflect.Value
+	keySetter *KeySetter
+	path      string // concrete path of val, e.g. Comments["3"].NickName
+	done      bool   // short-circuited by "?.", the remaining parts are skipped
+}
 
-	for _, part := range vr.parts {
-		// Before resolving the pointer, let's see if we have a method to call
-		// Problem with resolving the pointer is we're changing the receiver
-		isFunc := false
-		keySetter = nil
-		if part.typ == varTypeIdent {
-			funcValue := current.MethodByName(part.s)
-			if funcValue.IsValid() {
-				current = funcValue
-				isFunc = true
+// resolve walks the parts of the path from target. Every part is applied
+// to each location reached so far, a wildcard part replacing a location by
+// all of its elements and a recursive descent by every match below it.
+func (vr *variableResolver) resolve(target interface{}) ([]*Value, error) {
+	locations := []location{{val: reflect.ValueOf(scopeOf(target).target)}}
+
+	for idx, part := range vr.parts {
+		next := make([]location, 0, len(locations))
+		for _, loc := range locations {
+			if loc.done {
+				next = append(next, loc)
+				continue
 			}
-		}
-
-		if !isFunc {
-			// If current a pointer, resolve it
-			if current.Kind() == reflect.Ptr {
-				current = current.Elem()
-				if !current.IsValid() {
-					// Value is not valid (anymore)
-					return AsValue(nil), nil
-				}
+			if part.isDeep {
+				next = append(next, vr.deepScan(loc, part.s)...)
+				continue
 			}
-
-			// Look up which part must be called now
-			switch part.typ {
-			case varTypeInt:
-				// Calling an index is only possible for:
-				// * slices/arrays/strings
-				switch current.Kind() {
-				case reflect.String, reflect.Array, reflect.Slice:
-					if current.Len() > part.i {
-						current = current.Index(part.i)
-					} else {
-						return nil, fmt.Errorf("Index out of range: %d (variable %s)", part.i, vr.String())
-					}
-				default:
-					return nil, fmt.Errorf("Can't access an index on type %s (variable %s)",
-						current.Kind().String(), vr.String())
+			reached, err := vr.step(idx, part, loc, target)
+			if err != nil {
+				return nil, err
+			}
+			if part.fansOut() && !reached.done {
+				elems, err := vr.fanOut(reached)
+				if err != nil {
+					return nil, err
 				}
-			case varTypeIdent:
-				// debugging:
-				// fmt.Printf("now = %s (kind: %s)\n", part.s, current.Kind().String())
-
-				// Calling a field or key
-				switch current.Kind() {
-				case reflect.Struct:
-					current = current.FieldByName(part.s)
-				case reflect.Map:
-					current = current.MapIndex(reflect.ValueOf(part.s))
-				default:
-					return nil, fmt.Errorf("Can't access a field by name on type %s (variable %s)",
-						current.Kind().String(), vr.String())
+				if part.filter != nil {
+					elems, err = vr.filter(part.filter, elems, target)
+					if err != nil {
+						return nil, err
+					}
 				}
-			default:
-				panic("unimplemented")
+				next = append(next, elems...)
+				continue
 			}
+			next = append(next, reached)
 		}
+		locations = next
+	}
 
-		if !current.IsValid() {
-			// Value is not valid (anymore)
-			return AsValue(nil), nil
+	values := make([]*Value, 0, len(locations))
+	for _, loc := range locations {
+		if !loc.val.IsValid() {
+			// Value is not valid (e. g. NIL value)
+			values = append(values, &Value{keySetter: loc.keySetter, path: loc.path})
+			continue
 		}
+		values = append(values, &Value{val: loc.val, keySetter: loc.keySetter, path: loc.path})
+	}
+	return values, nil
+}
 
-		// If current is a reflect.ValueOf(Value), then unpack it
-		// Happens in function calls (as a return value) or by injecting
-		// into the execution context (e.g. in a for-loop)
-		if current.Type() == reflect.TypeOf(&Value{}) {
-			tmpValue := current.Interface().(*Value)
-			current = tmpValue.val
-		}
+// step applies the idx-th part of the path to loc.
+func (vr *variableResolver) step(idx int, part *variablePart, loc location, target interface{}) (location, error) {
+	current, path := loc.val, loc.path
+
+	// Values held by an interface (e.g. in a map[string]interface{})
+	// are navigated through their dynamic value
+	if current.Kind() == reflect.Interface {
+		current = current.Elem()
+	}
 
-		// Check whether this is an interface and resolve it where required
-		if current.Kind() == reflect.Interface {
-			current = reflect.ValueOf(current.Interface())
+	// Built-in and registered functions come before the methods of the
+	// target
+	if idx == 0 && part.typ == varTypeIdent && part.isFunctionCall {
+		if fn, ok := builtins[part.s]; ok {
+			return vr.callBuiltin(part, fn, target)
 		}
+		if fn, ok := scopeOf(target).env.lookup(part.s); ok {
+			result, args, err := vr.call(fn, part, target)
+			if err != nil {
+				return location{}, err
+			}
+			return location{val: result, path: part.s + "(" + args + ")"}, nil
+		}
+	}
 
-		// Handle index call
-		if part.isIndexCall {
+	// Going on from nil is an error, unless asked for with "?."
+	if idx > 0 && isNilReflect(current) {
+		if part.isSafe {
+			return location{done: true}, nil
+		}
+		return location{}, fmt.Errorf("Can't access '%s' on a nil value (variable %s)", part.name(), vr.String())
+	}
 
-			if current.Kind() != reflect.String && current.Kind() != reflect.Array && current.Kind() != reflect.Slice && current.Kind() != reflect.Map {
-				return nil, fmt.Errorf("'%s' can not be index access (it is %s)", vr.String(), current.Kind().String())
-			}
+	// Before resolving the pointer, let's see if we have a method to call
+	// Problem with resolving the pointer is we're changing the receiver
+	isFunc := false
+	var keySetter *KeySetter
+	if part.typ == varTypeIdent {
+		path = joinPath(path, part.s)
+		funcValue := methodByName(current, part.s)
+		if funcValue.IsValid() {
+			current = funcValue
+			isFunc = true
+		}
+	}
 
-			idxVal, err := part.indexArg.Evaluate(target)
-			if err != nil {
-				return nil, err
-			}
+	if !isFunc {
+		// If current a pointer, resolve it
+		if current.Kind() == reflect.Ptr {
+			current = current.Elem()
+		}
 
+		// Look up which part must be called now
+		switch part.typ {
+		case varTypeInt:
+			// Calling an index is only possible for:
+			// * slices/arrays/strings
 			switch current.Kind() {
 			case reflect.String, reflect.Array, reflect.Slice:
-				currentLen := current.Len()
-				resolveKey := idxVal.getResolvedValue()
-				if currentLen > idxVal.Integer() {
-					current = current.Index(idxVal.Integer())
-					keySetter = &KeySetter{
-						prev: &Value{val: current},
-						key:  resolveKey,
-					}
+				if current.Len() > part.i {
+					current = current.Index(part.i)
+					path = joinPath(path, strconv.Itoa(part.i))
 				} else {
-					if current.Kind() != reflect.Slice {
-						return nil, fmt.Errorf("Index out of range: %d (variable %s)", idxVal.Integer(), vr.String())
-					}
-					idxInt := int(idxVal.Integer())
-					wantLen := idxInt + 1
-					if wantLen > current.Cap() {
-						nav := reflect.MakeSlice(current.Type(), wantLen, wantLen*2)
-						reflect.Copy(nav, current)
-						current.Set(nav)
-						current.SetLen(wantLen)
-					} else {
-						current.SetLen(wantLen)
-					}
-					current = current.Index(idxInt)
-					keySetter = &KeySetter{
-						prev: &Value{val: current},
-						key:  resolveKey,
+					return location{}, fmt.Errorf("Index out of range: %d (variable %s)", part.i, vr.String())
+				}
+			default:
+				return location{}, fmt.Errorf("Can't access an index on type %s (variable %s)",
+					current.Kind().String(), vr.String())
+			}
+		case varTypeIdent:
+			// debugging:
+			// fmt.Printf("now = %s (kind: %s)\n", part.s, current.Kind().String())
+
+			// Calling a field or key
+			switch current.Kind() {
+			case reflect.Struct:
+				field, err := fieldByName(current, part.s)
+				if err != nil {
+					// Promoted through a nil embedded pointer
+					if part.isSafe {
+						return location{done: true}, nil
 					}
+					return location{}, fmt.Errorf("Can't access '%s' on a nil value (variable %s)", part.name(), vr.String())
 				}
+				current = field
 			case reflect.Map:
-				resolveKey := idxVal.getResolvedValue()
-				if idxVal.IsInteger() {
-					resolveKey = reflect.ValueOf(idxVal.String())
-				}
-				keySetter = &KeySetter{
-					prev: &Value{val: current},
-					key:  resolveKey,
-				}
-				current = current.MapIndex(resolveKey)
+				current = current.MapIndex(reflect.ValueOf(part.s))
 			default:
-				return nil, fmt.Errorf("Can't access an index on type %s (variable %s)",
+				return location{}, fmt.Errorf("Can't access a field by name on type %s (variable %s)",
 					current.Kind().String(), vr.String())
 			}
+		case varTypeVar:
+			value, ok := scopeOf(target).env.variable(part.s)
+			if !ok {
+				return location{}, fmt.Errorf("Undefined variable $%s", part.s)
+			}
+			current = reflect.ValueOf(value)
+			path = "$" + part.s
+		case varTypeIndex:
+			// Nothing to look up, the index or call applies to current
+		default:
+			panic("unimplemented")
+		}
+	}
+
+	if !current.IsValid() {
+		// Value is not valid (anymore), which is only an error when
+		// this part goes on with an index or a call
+		if part.isIndexCall || part.isSliceCall || part.isSelectCall || part.isFunctionCall || part.fansOut() {
+			return location{}, fmt.Errorf("Can't access an index or call on a nil value (variable %s)", vr.String())
+		}
+		return location{val: current, path: path}, nil
+	}
+
+	// If current is a reflect.ValueOf(Value), then unpack it
+	// Happens in function calls (as a return value) or by injecting
+	// into the execution context (e.g. in a for-loop)
+	if current.Type() == reflect.TypeOf(&Value{}) {
+		tmpValue := current.Interface().(*Value)
+		current = tmpValue.val
+	}
 
+	// Check whether this is an interface and resolve it where required,
+	// an interface reached by the last part is kept so it can be set
+	if current.Kind() == reflect.Interface && (idx < len(vr.parts)-1 || part.isIndexCall || part.isSelectCall || part.isFunctionCall || part.fansOut()) {
+		current = reflect.ValueOf(current.Interface())
+	}
+
+	// Handle index call
+	if part.isIndexCall {
+
+		if current.Kind() != reflect.String && current.Kind() != reflect.Array && current.Kind() != reflect.Slice && current.Kind() != reflect.Map {
+			return location{}, fmt.Errorf("'%s' can not be index access (it is %s)", vr.String(), current.Kind().String())
 		}
 
-		// Check if the part is a function call
-		if part.isFunctionCall || current.Kind() == reflect.Func {
-			// Check for callable
-			if current.Kind() != reflect.Func {
-				return nil, fmt.Errorf("'%s' is not a function (it is %s)", vr.String(), current.Kind().String())
+		idxVal, err := part.indexArg.Evaluate(target)
+		if err != nil {
+			return location{}, err
+		}
+
+		switch current.Kind() {
+		case reflect.String, reflect.Array, reflect.Slice:
+			if !idxVal.IsInteger() {
+				return location{}, fmt.Errorf("Index of '%s' must be an integer (not %s)", vr.String(), kindOf(idxVal))
+			}
+			idxInt := idxVal.Integer()
+			currentLen := (&Value{val: current}).Len()
+			if idxInt < 0 {
+				// Negative indexes count from the end
+				idxInt += currentLen
+				if idxInt < 0 {
+					return location{}, fmt.Errorf("Index out of range: %d (variable %s)", idxVal.Integer(), vr.String())
+				}
+			}
+			if current.Kind() == reflect.String {
+				// Strings are indexed by runes, which can't be set
+				if idxInt >= currentLen {
+					return location{}, fmt.Errorf("Index out of range: %d (variable %s)", idxVal.Integer(), vr.String())
+				}
+				current = reflect.ValueOf(string([]rune(current.String())[idxInt]))
+				path += fmt.Sprintf("[%d]", idxInt)
+				break
 			}
+			if idxInt >= currentLen {
+				// Setting past the end of a slice grows it
+				if current.Kind() != reflect.Slice || !current.CanSet() {
+					return location{}, fmt.Errorf("Index out of range: %d (variable %s)", idxVal.Integer(), vr.String())
+				}
+				wantLen := idxInt + 1
+				if wantLen > current.Cap() {
+					nav := reflect.MakeSlice(current.Type(), wantLen, wantLen*2)
+					reflect.Copy(nav, current)
+					current.Set(nav)
+					current.SetLen(wantLen)
+				} else {
+					current.SetLen(wantLen)
+				}
+			}
+			keySetter = &KeySetter{
+				prev: &Value{val: current},
+				key:  reflect.ValueOf(idxInt),
+			}
+			current = current.Index(idxInt)
+			path += fmt.Sprintf("[%d]", idxInt)
+		case reflect.Map:
+			// Integers index string keys as well, as in Comments[CommentIds[0]]
+			resolveKey, ok := mapKey(current.Type().Key(), idxVal)
+			if !ok {
+				return location{}, fmt.Errorf("Key of '%s' must be a %s (not %s)", vr.String(), current.Type().Key(), kindOf(idxVal))
+			}
+			keySetter = &KeySetter{
+				prev: &Value{val: current},
+				key:  resolveKey,
+			}
+			current = current.MapIndex(resolveKey)
+			path += "[" + literal(resolveKey) + "]"
+		default:
+			return location{}, fmt.Errorf("Can't access an index on type %s (variable %s)",
+				current.Kind().String(), vr.String())
+		}
+
+	}
+
+	// Handle key selection
+	if part.isSelectCall {
+		selected, err := vr.selectByKey(location{val: current, path: path}, part, target)
+		if err != nil {
+			return location{}, err
+		}
+		current = selected.val
+		keySetter = selected.keySetter
+		path = selected.path
+	}
+
+	// Handle slice call
+	if part.isSliceCall {
+		sliced, from, to, err := vr.slice(current, part, target)
+		if err != nil {
+			return location{}, err
+		}
+		current = sliced
+		keySetter = nil
+		path += fmt.Sprintf("[%d:%d]", from, to)
+	}
+
+	// Check if the part is a function call
+	if part.isFunctionCall || current.Kind() == reflect.Func {
+		// Check for callable
+		if current.Kind() != reflect.Func {
+			return location{}, fmt.Errorf("'%s' is not a function (it is %s)", vr.String(), current.Kind().String())
+		}
+
+		result, args, err := vr.call(current, part, target)
+		if err != nil {
+			return location{}, err
+		}
+		current = result
+		path += "(" + args + ")"
+	}
+
+	return location{val: current, keySetter: keySetter, path: path}, nil
+}
+
+// call evaluates the arguments of part and calls fn with them, also
+// returning the arguments as written in a concrete path.
+func (vr *variableResolver) call(fn reflect.Value, part *variablePart, target interface{}) (reflect.Value, string, error) {
+	// Check for correct function syntax and types
+	// func(*Value, ...) *Value
+	t := fn.Type()
+
+	// Input arguments
+	if len(part.callingArgs) != t.NumIn() && !(len(part.callingArgs) >= t.NumIn()-1 && t.IsVariadic()) {
+		return reflect.Value{}, "",
+			fmt.Errorf("Function input argument count (%d) of '%s' must be equal to the calling argument count (%d).",
+				t.NumIn(), vr.String(), len(part.callingArgs))
+	}
+
+	// Output arguments
+	if t.NumOut() != 1 {
+		return reflect.Value{}, "", fmt.Errorf("'%s' must have exactly 1 output argument", vr.String())
+	}
 
-			// Check for correct function syntax and types
-			// func(*Value, ...) *Value
-			t := current.Type()
+	// Evaluate all parameters
+	var parameters []reflect.Value
+	args := make([]string, 0, len(part.callingArgs))
 
-			// Input arguments
-			if len(part.callingArgs) != t.NumIn() && !(len(part.callingArgs) >= t.NumIn()-1 && t.IsVariadic()) {
-				return nil,
-					fmt.Errorf("Function input argument count (%d) of '%s' must be equal to the calling argument count (%d).",
-						t.NumIn(), vr.String(), len(part.callingArgs))
+	numArgs := t.NumIn()
+	isVariadic := t.IsVariadic()
+	var fnArg reflect.Type
+
+	for idx, arg := range part.callingArgs {
+		pv, err := arg.Evaluate(target)
+		if err != nil {
+			return reflect.Value{}, "", err
+		}
+		args = append(args, literal(pv.getResolvedValue()))
+
+		if isVariadic {
+			if idx >= t.NumIn()-1 {
+				fnArg = t.In(numArgs - 1).Elem()
+			} else {
+				fnArg = t.In(idx)
 			}
+		} else {
+			fnArg = t.In(idx)
+		}
 
-			// Output arguments
-			if t.NumOut() != 1 {
-				return nil, fmt.Errorf("'%s' must have exactly 1 output argument", vr.String())
+		if fnArg != valueType {
+			// Function's argument is not a *Value, then we have to check whether input argument can be given as the function's argument
+			param, ok := callArgument(fnArg, pv)
+			if !ok {
+				if !isVariadic || idx < numArgs-1 {
+					return reflect.Value{}, "", fmt.Errorf("Function input argument %d of '%s' must be of type %s or *Value (not %T).",
+						idx, vr.String(), fnArg.String(), pv.Interface())
+				}
+				return reflect.Value{}, "", fmt.Errorf("Function variadic input argument of '%s' must be of type %s or *Value (not %T).",
+					vr.String(), fnArg.String(), pv.Interface())
 			}
+			parameters = append(parameters, param)
+		} else {
+			// Function's argument is a *Value
+			parameters = append(parameters, reflect.ValueOf(pv))
+		}
+	}
 
-			// Evaluate all parameters
-			var parameters []reflect.Value
+	// Check if any of the values are invalid
+	for _, p := range parameters {
+		if p.Kind() == reflect.Invalid {
+			return reflect.Value{}, "", fmt.Errorf("Calling a function using an invalid parameter")
+		}
+	}
 
-			numArgs := t.NumIn()
-			isVariadic := t.IsVariadic()
-			var fnArg reflect.Type
+	// Call it and get first return parameter back
+	rv := fn.Call(parameters)[0]
 
-			for idx, arg := range part.callingArgs {
-				pv, err := arg.Evaluate(target)
-				if err != nil {
-					return nil, err
-				}
+	if rv.Type() != reflect.TypeOf(new(Value)) {
+		return reflect.ValueOf(rv.Interface()), strings.Join(args, ", "), nil
+	}
+	// Return the function call value
+	return rv.Interface().(*Value).val, strings.Join(args, ", "), nil
+}
 
-				if isVariadic {
-					if idx >= t.NumIn()-1 {
-						fnArg = t.In(numArgs - 1).Elem()
-					} else {
-						fnArg = t.In(idx)
-					}
-				} else {
-					fnArg = t.In(idx)
-				}
+// callArgument returns the argument arg as given for a parameter of type
+// param other than *Value. It must be of that type, or implement it when
+// param is an interface, nil then being the zero interface. Numbers are
+// converted to the number type of param, to integers only when their value
+// fits in it.
+func callArgument(param reflect.Type, arg *Value) (reflect.Value, bool) {
+	av := reflect.ValueOf(arg.Interface())
+	switch {
+	case !av.IsValid():
+		if param.Kind() == reflect.Interface {
+			return reflect.Zero(param), true
+		}
+	case av.Type() == param:
+		return av, true
+	case param.Kind() == reflect.Interface:
+		return av, av.Type().AssignableTo(param)
+	case arg.IsNumber() && isNumberKind(param.Kind()) && av.Type().ConvertibleTo(param):
+		converted := av.Convert(param)
+		return converted, isFloatKind(param.Kind()) || valuesEqual(&Value{val: converted}, arg)
+	}
+	return reflect.Value{}, false
+}
 
-				if fnArg != reflect.TypeOf(new(Value)) {
-					// Function's argument is not a *Value, then we have to check whether input argument is of the same type as the function's argument
-					if !isVariadic {
-						if fnArg != reflect.TypeOf(pv.Interface()) && fnArg.Kind() != reflect.Interface {
-							return nil, fmt.Errorf("Function input argument %d of '%s' must be of type %s or *Value (not %T).",
-								idx, vr.String(), fnArg.String(), pv.Interface())
-						}
-						// Function's argument has another type, using the interface-value
-						parameters = append(parameters, reflect.ValueOf(pv.Interface()))
-					} else {
-						if fnArg != reflect.TypeOf(pv.Interface()) && fnArg.Kind() != reflect.Interface {
-							return nil, fmt.Errorf("Function variadic input argument of '%s' must be of type %s or *Value (not %T).",
-								vr.String(), fnArg.String(), pv.Interface())
-						}
-						// Function's argument has another type, using the interface-value
-						parameters = append(parameters, reflect.ValueOf(pv.Interface()))
+// callBuiltin evaluates the arguments of part and calls the built-in fn.
+func (vr *variableResolver) callBuiltin(part *variablePart, fn builtin, target interface{}) (location, error) {
+	if len(part.callingArgs) < fn.minArgs || (fn.maxArgs >= 0 && len(part.callingArgs) > fn.maxArgs) {
+		return location{}, fmt.Errorf("Function '%s' takes %s argument(s), not %d", part.s, fn.arity(), len(part.callingArgs))
+	}
+
+	args := make([]*Value, 0, len(part.callingArgs))
+	literals := make([]string, 0, len(part.callingArgs))
+	for _, arg := range part.callingArgs {
+		pv, err := arg.Evaluate(target)
+		if err != nil {
+			return location{}, err
+		}
+		args = append(args, pv)
+		literals = append(literals, literal(pv.getResolvedValue()))
+	}
+
+	result, err := fn.call(args)
+	if err != nil {
+		return location{}, fmt.Errorf("Function '%s': %s", part.s, err)
+	}
+	return location{val: result.val, path: part.s + "(" + strings.Join(literals, ", ") + ")"}, nil
+}
+
+// joinPath appends a field name or number to a concrete path.
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// literal writes v the way it's written in an expression, as in the index
+// of a concrete path.
+func literal(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	if v.Kind() == reflect.String {
+		return strconv.Quote(v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// fanOut lists the elements of a slice, array or map, each one with its
+// own setter. Map entries are listed in key order.
+func (vr *variableResolver) fanOut(loc location) ([]location, error) {
+	current := loc.val
+	for current.Kind() == reflect.Interface || current.Kind() == reflect.Ptr {
+		if current.IsNil() {
+			return nil, nil
+		}
+		current = current.Elem()
+	}
+
+	switch current.Kind() {
+	case reflect.Array, reflect.Slice:
+		elems := make([]location, 0, current.Len())
+		for i := 0; i < current.Len(); i++ {
+			elems = append(elems, location{
+				val: current.Index(i),
+				keySetter: &KeySetter{
+					prev: &Value{val: current},
+					key:  reflect.ValueOf(i),
+				},
+				path: fmt.Sprintf("%s[%d]", loc.path, i),
+			})
+		}
+		return elems, nil
+	case reflect.Map:
+		keys := current.MapKeys()
+		sortKeys(keys)
+		elems := make([]location, 0, len(keys))
+		for _, key := range keys {
+			elems = append(elems, location{
+				val: current.MapIndex(key),
+				keySetter: &KeySetter{
+					prev: &Value{val: current},
+					key:  key,
+				},
+				path: loc.path + "[" + literal(key) + "]",
+			})
+		}
+		return elems, nil
+	default:
+		return nil, fmt.Errorf("'%s' can not be iterated (it is %s)", vr.String(), current.Kind().String())
+	}
+}
+
+// visit is a pointer or map met by deepScan.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// deepScan returns every struct field or map entry named name at any depth
+// below loc, in the order a depth-first walk meets them. Each pointer and
+// map is walked once, so that shared and cyclic data take a time linear in
+// its size. When one is met again through another field, the matches it
+// holds itself are found again under that field, so that a value shared by
+// several fields is found under each of them, while those below its own
+// pointers and maps are only found under the first path.
+func (vr *variableResolver) deepScan(loc location, name string) []location {
+	var found []location
+	// The matches held by the pointers and maps walked, with paths
+	// relative to them
+	walked := map[visit][]location{}
+	walking := map[visit]bool{}
+	var own []location
+	var base string
+
+	var walk func(loc location)
+	walk = func(loc location) {
+		var entered []visit
+		current := loc.val
+		for current.Kind() == reflect.Interface || current.Kind() == reflect.Ptr || current.Kind() == reflect.Map {
+			if current.IsNil() {
+				return
+			}
+			if current.Kind() != reflect.Interface {
+				v := visit{ptr: current.Pointer(), typ: current.Type()}
+				if walking[v] {
+					return
+				}
+				if held, ok := walked[v]; ok {
+					for _, match := range held {
+						match.path = relativePath(loc.path, match.path)
+						found = append(found, match)
 					}
-				} else {
-					// Function's argument is a *Value
-					parameters = append(parameters, reflect.ValueOf(pv))
+					return
 				}
+				walking[v] = true
+				entered = append(entered, v)
+			}
+			if current.Kind() == reflect.Map {
+				break
 			}
+			current = current.Elem()
+		}
 
-			// Check if any of the values are invalid
-			for _, p := range parameters {
-				if p.Kind() == reflect.Invalid {
-					return nil, fmt.Errorf("Calling a function using an invalid parameter")
+		if len(entered) > 0 {
+			prevOwn, prevBase := own, base
+			own, base = nil, loc.path
+			defer func() {
+				for _, v := range entered {
+					delete(walking, v)
+					walked[v] = own
 				}
+				own, base = prevOwn, prevBase
+			}()
+		}
+
+		match := func(elem location) {
+			found = append(found, elem)
+			elem.path = elem.path[len(base):]
+			own = append(own, elem)
+		}
+
+		switch current.Kind() {
+		case reflect.Struct:
+			typ := current.Type()
+			for i := 0; i < typ.NumField(); i++ {
+				field := typ.Field(i)
+				if field.PkgPath != "" {
+					// Unexported
+					continue
+				}
+				elem := location{
+					val:  current.Field(i),
+					path: joinPath(loc.path, field.Name),
+				}
+				if field.Name == upperFirst(name) {
+					match(elem)
+				}
+				walk(elem)
 			}
+		case reflect.Map, reflect.Array, reflect.Slice:
+			elems, _ := vr.fanOut(location{val: current, path: loc.path})
+			for _, elem := range elems {
+				key := elem.keySetter.key
+				if current.Kind() == reflect.Map && key.Kind() == reflect.String && key.String() == name {
+					match(elem)
+				}
+				walk(elem)
+			}
+		}
+	}
 
-			// Call it and get first return parameter back
-			rv := current.Call(parameters)[0]
+	walk(loc)
+	return found
+}
 
-			if rv.Type() != reflect.TypeOf(new(Value)) {
-				current = reflect.ValueOf(rv.Interface())
-			} else {
-				// Return the function call value
-				current = rv.Interface().(*Value).val
+// relativePath appends rel, a path relative to a value, to the path of
+// that value.
+func relativePath(path, rel string) string {
+	if strings.HasPrefix(rel, ".") || strings.HasPrefix(rel, "[") {
+		return path + rel
+	}
+	return joinPath(path, rel)
+}
+
+// filter keeps the elements for which cond is true, cond being evaluated
+// with the element as target. Nil elements never match.
+func (vr *variableResolver) filter(cond IEvaluator, elems []location, target interface{}) ([]location, error) {
+	sc := scopeOf(target)
+	kept := elems[:0]
+	for _, elem := range elems {
+		val := elem.val
+		if val.Kind() == reflect.Interface {
+			val = val.Elem()
+		}
+		if isNilReflect(val) {
+			continue
+		}
+		ok, err := cond.Evaluate(sc.with(val.Interface()))
+		if err != nil {
+			return nil, err
+		}
+		if ok.IsTrue() {
+			kept = append(kept, elem)
+		}
+	}
+	return kept, nil
+}
+
+// selectByKey finds the first element of a slice, array or map whose field
+// (or key) part.selectKey equals the selection argument. Nothing is found
+// when there's no such element.
+func (vr *variableResolver) selectByKey(loc location, part *variablePart, target interface{}) (location, error) {
+	want, perr := part.selectArg.Evaluate(target)
+	if perr != nil {
+		return location{}, perr
+	}
+	elems, err := vr.fanOut(loc)
+	if err != nil {
+		return location{}, err
+	}
+
+	for _, elem := range elems {
+		field := elem.val
+		for (field.Kind() == reflect.Interface || field.Kind() == reflect.Ptr) && !field.IsNil() {
+			field = field.Elem()
+		}
+		switch field.Kind() {
+		case reflect.Struct:
+			// Nothing matches through a nil embedded pointer
+			field, _ = fieldByName(field, part.selectKey)
+		case reflect.Map:
+			key, ok := mapKey(field.Type().Key(), AsValue(part.selectKey))
+			if !ok {
+				continue
 			}
+			field = field.MapIndex(key)
+		default:
+			continue
+		}
+		if field.IsValid() && valuesEqual(&Value{val: field}, want) {
+			return elem, nil
 		}
 	}
+	return location{path: loc.path + "[" + part.selectKey + "=" + literal(want.getResolvedValue()) + "]"}, nil
+}
 
-	if !current.IsValid() {
-		// Value is not valid (e. g. NIL value)
-		return AsValueWithSetter(nil, keySetter), nil
+// sortKeys orders map keys, numerically or lexically when they can be
+// compared and by their printed form otherwise.
+func sortKeys(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool {
+		c, err := compareValues(&Value{val: keys[i]}, &Value{val: keys[j]})
+		if err != nil {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		}
+		return c < 0
+	})
+}
+
+// isNilReflect reports whether nothing can be reached from v: an invalid
+// value, a nil pointer or a nil interface.
+func isNilReflect(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// fansOut reports whether the part may replace a value by several ones.
+func (part *variablePart) fansOut() bool {
+	return part.isWildcard || part.isDeep || part.filter != nil
+}
+
+// name describes the part in error messages.
+func (part *variablePart) name() string {
+	switch part.typ {
+	case varTypeInt:
+		return strconv.Itoa(part.i)
+	case varTypeIdent:
+		return part.s
+	case varTypeVar:
+		return "$" + part.s
+	default:
+		return "[]"
+	}
+}
+
+// chainPart returns the part a call or an index applies to: the last one,
+// or a new varTypeIndex part when the last one already has its own.
+func (vr *variableResolver) chainPart() *variablePart {
+	part := vr.parts[len(vr.parts)-1]
+	if part.isIndexCall || part.isSliceCall || part.isSelectCall || part.fansOut() || part.isFunctionCall {
+		part = &variablePart{typ: varTypeIndex}
+		vr.parts = append(vr.parts, part)
+	}
+	return part
+}
+
+// slice applies a slice call to current, also returning the bounds it
+// used. Strings are sliced by runes.
+func (vr *variableResolver) slice(current reflect.Value, part *variablePart, target interface{}) (reflect.Value, int, int, error) {
+	switch current.Kind() {
+	case reflect.String, reflect.Array, reflect.Slice:
+	default:
+		return reflect.Value{}, 0, 0, fmt.Errorf("'%s' can not be sliced (it is %s)", vr.String(), current.Kind().String())
 	}
 
-	return &Value{val: current, keySetter: keySetter}, nil
+	length := (&Value{val: current}).Len()
+	bounds := []int{0, length}
+	for i, arg := range []functionCallArgument{part.sliceFrom, part.sliceTo} {
+		if arg == nil {
+			continue
+		}
+		bound, err := arg.Evaluate(target)
+		if err != nil {
+			return reflect.Value{}, 0, 0, err
+		}
+		if !bound.IsInteger() {
+			return reflect.Value{}, 0, 0, fmt.Errorf("Slice bound of '%s' must be an integer (not %s)", vr.String(), kindOf(bound))
+		}
+		bounds[i] = bound.Integer()
+		if bounds[i] < 0 {
+			// Negative bounds count from the end
+			bounds[i] += length
+		}
+	}
+
+	from, to := bounds[0], bounds[1]
+	if from < 0 || to > length || from > to {
+		return reflect.Value{}, 0, 0, fmt.Errorf("Slice bounds out of range [%d:%d] with length %d (variable %s)", from, to, length, vr.String())
+	}
+
+	switch current.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(string([]rune(current.String())[from:to])), from, to, nil
+	case reflect.Array:
+		if !current.CanAddr() {
+			// Only addressable arrays can be sliced
+			addressable := reflect.New(current.Type()).Elem()
+			addressable.Set(current)
+			current = addressable
+		}
+	}
+	return current.Slice(from, to), from, to, nil
 }
 
-func (vr *variableResolver) GetPositionToken() *Token {
+func (vr *variableResolver) GetPositionToken() *token.Token {
 	return vr.locationToken
 }
 
@@ -334,53 +994,256 @@ type variablePart struct {
 	s   string
 	i   int
 
+	isSafe         bool // reached through "?.", a nil value short-circuits the path
 	isIndexCall    bool
+	isSliceCall    bool
+	isSelectCall   bool
+	isWildcard     bool       // "[*]", fans out over every element
+	isDeep         bool       // "..name", fans out over every match at any depth
+	filter         IEvaluator // "[?(...)]", fans out over the matching elements
 	isFunctionCall bool
 	indexArg       functionCallArgument
+	sliceFrom      functionCallArgument   // nil when omitted, as in [:j]
+	sliceTo        functionCallArgument   // nil when omitted, as in [i:]
+	selectKey      string                 // field compared by a key selection, ID in [ID=3]
+	selectArg      functionCallArgument   // value compared by a key selection, 3 in [ID=3]
 	callingArgs    []functionCallArgument // needed for a function call, represents all argument nodes (INode supports nested function calls)
 }
 
+// ParseExp parses a whole expression, binary operators and conditionals
+// included.
 func (p *Parser) ParseExp() (IEvaluator, *Error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
 
-	if p.Match(TokenSymbol, "(") != nil {
-		expr, err := p.ParseExp()
+	t := p.Match(token.TypePunctuation, "?")
+	if t == nil {
+		return cond, nil
+	}
+
+	// cond '?' expression ':' expression, nesting to the right
+	then, err := p.ParseExp()
+	if err != nil {
+		return nil, err
+	}
+	if p.Match(token.TypePunctuation, ":") == nil {
+		return nil, p.Error("Expected ':' in conditional expression.", nil)
+	}
+	otherwise, err := p.ParseExp()
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalOperation{
+		locationToken: t,
+		cond:          cond,
+		then:          then,
+		otherwise:     otherwise,
+	}, nil
+}
+
+// parseBinary is a precedence climbing parser over BinaryOperators: it
+// keeps folding operators into the left operand for as long as their
+// precedence is at least minPrecedence.
+func (p *Parser) parseBinary(minPrecedence int) (IEvaluator, *Error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.PeekType(token.TypeOperator)
+		if t == nil {
+			break
+		}
+		op, ok := BinaryOperators[t.Value]
+		if !ok || op.Precedence < minPrecedence {
+			break
+		}
+		p.Consume()
+
+		nextPrecedence := op.Precedence
+		if op.Associativity == AssociativityOpLeft {
+			nextPrecedence++
+		}
+		right, err := p.parseBinary(nextPrecedence)
 		if err != nil {
 			return nil, err
 		}
-		if p.Match(TokenSymbol, ")") == nil {
-			return nil, p.Error("Closing bracket expected after expression", nil)
+		left, err = newBinaryOperation(t, left, right)
+		if err != nil {
+			return nil, err
 		}
-		return expr, nil
 	}
 
-	t := p.Current()
+	return left, nil
+}
 
+// parseUnary parses an operand optionally prefixed by unary operators. The
+// operand binds as tightly as the operator precedence says, so "-a * b"
+// negates a only while "!a == b" negates a before comparing.
+func (p *Parser) parseUnary() (IEvaluator, *Error) {
+	t := p.PeekType(token.TypeOperator)
 	if t == nil {
-		return nil, p.Error("Unexpect EOF, expected an identifier", p.lastToken)
+		return p.parsePrimary()
+	}
+	op, ok := UnaryOperators[t.Value]
+	if !ok {
+		return p.parsePrimary()
 	}
+	p.Consume()
 
-	switch t.Typ {
-	case TokenNumber:
-		p.Consume()
-		i, err := strconv.Atoi(t.Val)
+	operand, err := p.parseBinary(op.Precedence)
+	if err != nil {
+		return nil, err
+	}
+	return &unaryOperation{
+		locationToken: t,
+		op:            t.Value,
+		operand:       operand,
+	}, nil
+}
+
+// parseNumber turns a number token into an int or float resolver. Literals
+// may be written in hex (0xFF), with an exponent (1e6) and with digit
+// separators (1_000).
+func (p *Parser) parseNumber(t *token.Token) (IEvaluator, *Error) {
+	literal := strings.Replace(t.Value, "_", "", -1)
+	isHex := strings.HasPrefix(literal, "0x") || strings.HasPrefix(literal, "0X")
+
+	if !isHex && strings.ContainsAny(literal, ".eE") {
+		f, err := strconv.ParseFloat(literal, 64)
 		if err != nil {
+			if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+				return nil, p.Error(fmt.Sprintf("Number %s overflows float64", t.Value), t)
+			}
 			return nil, p.Error(err.Error(), t)
 		}
-		nr := &intResolver{
+		return &floatResolver{
 			locationToken: t,
-			val:           i,
+			val:           f,
+		}, nil
+	}
+
+	base := 10
+	if isHex {
+		base = 16
+		literal = literal[2:]
+	}
+	i, err := strconv.ParseInt(literal, base, strconv.IntSize)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return nil, p.Error(fmt.Sprintf("Number %s overflows int", t.Value), t)
 		}
-		return nr, nil
-	case TokenString:
+		return nil, p.Error(err.Error(), t)
+	}
+	return &intResolver{
+		locationToken: t,
+		val:           int(i),
+	}, nil
+}
+
+// parseArray parses the items of an array literal, the opening '[' being
+// already consumed: '[' Comma-separated list of expressions ']'
+func (p *Parser) parseArray(t *token.Token) (IEvaluator, *Error) {
+	ar := &arrayResolver{locationToken: t}
+	for p.Match(token.TypePunctuation, "]") == nil {
+		if len(ar.items) > 0 {
+			if p.Match(token.TypePunctuation, ",") == nil {
+				return nil, p.Error("Missing comma or closing bracket in array.", nil)
+			}
+			// A trailing comma is allowed
+			if p.Match(token.TypePunctuation, "]") != nil {
+				break
+			}
+		}
+		item, err := p.ParseExp()
+		if err != nil {
+			return nil, err
+		}
+		ar.items = append(ar.items, item)
+	}
+	return ar, nil
+}
+
+// parseMap parses the entries of a map literal, the opening '{' being
+// already consumed: '{' Comma-separated list of key ':' expression '}'.
+// Keys are strings, names or numbers and are always stored as strings.
+func (p *Parser) parseMap(t *token.Token) (IEvaluator, *Error) {
+	mr := &mapResolver{locationToken: t}
+	for p.Match(token.TypePunctuation, "}") == nil {
+		if len(mr.keys) > 0 {
+			if p.Match(token.TypePunctuation, ",") == nil {
+				return nil, p.Error("Missing comma or closing bracket in map.", nil)
+			}
+			// A trailing comma is allowed
+			if p.Match(token.TypePunctuation, "}") != nil {
+				break
+			}
+		}
+		key := p.Current()
+		switch key.Type {
+		case token.TypeString, token.TypeName, token.TypeKeyword, token.TypeNumber:
+			p.Consume()
+		default:
+			return nil, p.Error("Expected a string, name or number as map key.", key)
+		}
+		if p.Match(token.TypePunctuation, ":") == nil {
+			return nil, p.Error("Missing ':' after map key.", nil)
+		}
+		value, err := p.ParseExp()
+		if err != nil {
+			return nil, err
+		}
+		mr.keys = append(mr.keys, key.Value)
+		mr.values = append(mr.values, value)
+	}
+	return mr, nil
+}
+
+// parsePrimary parses a single operand: a literal, a variable path or a
+// parenthesized expression.
+func (p *Parser) parsePrimary() (IEvaluator, *Error) {
+
+	if t := p.Match(token.TypePunctuation, "["); t != nil {
+		return p.parseArray(t)
+	}
+	if t := p.Match(token.TypePunctuation, "{"); t != nil {
+		return p.parseMap(t)
+	}
+
+	if p.Match(token.TypePunctuation, "(") != nil {
+		expr, err := p.ParseExp()
+		if err != nil {
+			return nil, err
+		}
+		if p.Match(token.TypePunctuation, ")") == nil {
+			return nil, p.Error("Closing bracket expected after expression", nil)
+		}
+		return expr, nil
+	}
+
+	t := p.Current()
+
+	if t.Type == token.TypeEOF {
+		return nil, p.Error("Unexpected EOF, expected an identifier", p.lastToken)
+	}
+
+	switch t.Type {
+	case token.TypeNumber:
+		p.Consume()
+		return p.parseNumber(t)
+	case token.TypeString:
 		p.Consume()
 		sr := &stringResolver{
 			locationToken: t,
-			val:           t.Val,
+			val:           t.Value,
 		}
 		return sr, nil
-	case TokenKeyword:
+	case token.TypeKeyword:
 		p.Consume()
-		switch t.Val {
+		switch t.Value {
 		case "true":
 			br := &boolResolver{
 				locationToken: t,
@@ -393,43 +1256,96 @@ func (p *Parser) ParseExp() (IEvaluator, *Error) {
 				val:           false,
 			}
 			return br, nil
+		case "nil", "null":
+			return &nilResolver{locationToken: t}, nil
 		default:
 			return nil, p.Error("This keyword is not allowed here.", nil)
 		}
 	}
 
-	if t.Typ != TokenIdentifier {
-		return nil, p.Error("Expected either a number, string, keyword or identifier.", t)
-	}
-
 	resolver := &variableResolver{
 		locationToken: t,
 	}
 
-	resolver.parts = append(resolver.parts, &variablePart{
-		typ: varTypeIdent,
-		s:   t.Val,
-	})
-
-	p.Consume()
+	switch {
+	case t.Test(token.TypePunctuation, ".") && p.PeekN(1, token.TypePunctuation, ".") != nil:
+		// Recursive descent from the target, the loop below parses it
+	case t.Type != token.TypeName:
+		return nil, p.Error("Expected either a number, string, keyword or identifier.", t)
+	case strings.HasPrefix(t.Value, "$"):
+		resolver.parts = append(resolver.parts, &variablePart{
+			typ: varTypeVar,
+			s:   t.Value[1:],
+		})
+		p.Consume()
+	case t.Value == "@":
+		// The target itself, nothing to look up
+		resolver.parts = append(resolver.parts, &variablePart{
+			typ: varTypeIndex,
+			s:   t.Value,
+		})
+		p.Consume()
+	default:
+		resolver.parts = append(resolver.parts, &variablePart{
+			typ: varTypeIdent,
+			s:   t.Value,
+		})
+		p.Consume()
+	}
 
 variableLoop:
 	for p.Remaining() > 0 {
 		t = p.Current()
 
-		if p.Match(TokenSymbol, ".") != nil {
+		if p.Match(token.TypePunctuation, "?.") != nil {
+			// Safe navigation, the new part is only resolved when the
+			// current value is not nil: "?." name or "?." '[' index ']'
+			t2 := p.Current()
+			switch {
+			case t2.Type == token.TypeName:
+				resolver.parts = append(resolver.parts, &variablePart{
+					typ:    varTypeIdent,
+					s:      t2.Value,
+					isSafe: true,
+				})
+				p.Consume()
+			case t2.Test(token.TypePunctuation, "["):
+				// A fresh part, so the following bracket applies to it
+				resolver.parts = append(resolver.parts, &variablePart{
+					typ:    varTypeIndex,
+					isSafe: true,
+				})
+			default:
+				return nil, p.Error("Expected a name or an index after '?.'", t2)
+			}
+			continue variableLoop
+		} else if p.Match(token.TypePunctuation, ".") != nil {
+			if p.Match(token.TypePunctuation, ".") != nil {
+				// Recursive descent, '.' '.' name
+				t3 := p.Current()
+				if t3.Type != token.TypeName {
+					return nil, p.Error("Expected a name after '..'", t3)
+				}
+				resolver.parts = append(resolver.parts, &variablePart{
+					typ:    varTypeIdent,
+					s:      t3.Value,
+					isDeep: true,
+				})
+				p.Consume()
+				continue variableLoop
+			}
 			t2 := p.Current()
-			if t2 != nil {
-				switch t2.Typ {
-				case TokenIdentifier:
+			if t2.Type != token.TypeEOF {
+				switch t2.Type {
+				case token.TypeName:
 					resolver.parts = append(resolver.parts, &variablePart{
 						typ: varTypeIdent,
-						s:   t2.Val,
+						s:   t2.Value,
 					})
 					p.Consume()
 					continue variableLoop
-				case TokenNumber:
-					i, err := strconv.Atoi(t2.Val)
+				case token.TypeNumber:
+					i, err := strconv.Atoi(t2.Value)
 					if err != nil {
 						return nil, p.Error(err.Error(), t2)
 					}
@@ -445,10 +1361,10 @@ variableLoop:
 			} else {
 				return nil, p.Error("Unexpected EOF", p.lastToken)
 			}
-		} else if p.Match(TokenSymbol, "(") != nil {
+		} else if p.Match(token.TypePunctuation, "(") != nil {
 			// Function call
 			// FunctionName '(' Comma-separated list of expressions ')'
-			part := resolver.parts[len(resolver.parts)-1]
+			part := resolver.chainPart()
 			part.isFunctionCall = true
 		argumentLoop:
 			for {
@@ -456,7 +1372,7 @@ variableLoop:
 					return nil, p.Error("Unexpected EOF, expected function call argument list.", p.lastToken)
 				}
 
-				if p.Peek(TokenSymbol, ")") == nil {
+				if p.Peek(token.TypePunctuation, ")") == nil {
 					// No closing bracket, so we're parsing an expression
 					exprArg, err := p.ParseExp()
 					if err != nil {
@@ -464,12 +1380,12 @@ variableLoop:
 					}
 					part.callingArgs = append(part.callingArgs, exprArg)
 
-					if p.Match(TokenSymbol, ")") != nil {
+					if p.Match(token.TypePunctuation, ")") != nil {
 						// If there's a closing bracket after an expression, we will stop parsing the arguments
 						break argumentLoop
 					} else {
 						// If there's NO closing bracket, there MUST be an comma
-						if p.Match(TokenSymbol, ",") == nil {
+						if p.Match(token.TypePunctuation, ",") == nil {
 							return nil, p.Error("Missing comma or closing bracket after argument.", nil)
 						}
 					}
@@ -482,21 +1398,82 @@ variableLoop:
 			}
 			// We're done parsing the function call, next variable part
 			continue variableLoop
-		} else if p.Match(TokenSymbol, "[") != nil {
-			part := resolver.parts[len(resolver.parts)-1]
-			part.isIndexCall = true
+		} else if p.Match(token.TypePunctuation, "[") != nil {
+			// Index, slice, wildcard, key selection or filter call
+			// '[' expression ']' or '[' expression? ':' expression? ']'
+			// or '[' '*' ']' or '[' name '=' expression ']'
+			// or '[' '?' '(' expression ')' ']'
+			part := resolver.chainPart()
 			if p.Remaining() == 0 {
 				return nil, p.Error("Unexpected EOF, expected index call expression.", p.lastToken)
 			}
-			if p.Peek(TokenSymbol, "]") != nil {
+			if p.Peek(token.TypePunctuation, "]") != nil {
 				return nil, p.Error("Unexpected ], expected index argument.", p.lastToken)
 			}
-			exprArg, err := p.ParseExp()
-			if err != nil {
-				return nil, err
+			if p.Peek(token.TypeOperator, "*") != nil && p.PeekN(1, token.TypePunctuation, "]") != nil {
+				// Wildcard, '[' '*' ']'
+				p.Consume()
+				p.Consume()
+				part.isWildcard = true
+				continue variableLoop
+			}
+			if p.PeekType(token.TypeName) != nil && p.PeekN(1, token.TypeOperator, "=") != nil {
+				// Key selection, '[' name '=' expression ']'
+				key := p.Current()
+				p.Consume()
+				p.Consume()
+				arg, err := p.ParseExp()
+				if err != nil {
+					return nil, err
+				}
+				if p.Match(token.TypePunctuation, "]") == nil {
+					return nil, p.Error("Expected ']' after key selection.", nil)
+				}
+				part.isSelectCall = true
+				part.selectKey = key.Value
+				part.selectArg = arg
+				continue variableLoop
+			}
+			if p.Match(token.TypePunctuation, "?") != nil {
+				// Filter, '[' '?' '(' expression ')' ']'
+				if p.Match(token.TypePunctuation, "(") == nil {
+					return nil, p.Error("Expected '(' after '?' in filter.", nil)
+				}
+				cond, err := p.ParseExp()
+				if err != nil {
+					return nil, err
+				}
+				if p.Match(token.TypePunctuation, ")") == nil || p.Match(token.TypePunctuation, "]") == nil {
+					return nil, p.Error("Expected ')]' after filter expression.", nil)
+				}
+				part.filter = cond
+				continue variableLoop
+			}
+			var exprArg IEvaluator
+			if p.Peek(token.TypePunctuation, ":") == nil {
+				var err *Error
+				exprArg, err = p.ParseExp()
+				if err != nil {
+					return nil, err
+				}
+			}
+			if p.Match(token.TypePunctuation, ":") != nil {
+				part.isSliceCall = true
+				if exprArg != nil {
+					part.sliceFrom = exprArg
+				}
+				if p.Peek(token.TypePunctuation, "]") == nil {
+					sliceTo, err := p.ParseExp()
+					if err != nil {
+						return nil, err
+					}
+					part.sliceTo = sliceTo
+				}
+			} else {
+				part.isIndexCall = true
+				part.indexArg = exprArg
 			}
-			part.indexArg = exprArg
-			if p.Match(TokenSymbol, "]") == nil {
+			if p.Match(token.TypePunctuation, "]") == nil {
 				return nil, p.Error("Miss [ for index argument call.", p.lastToken)
 			}
 			continue variableLoop
@@ -0,0 +1,703 @@
+package el
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/runcom/el/token"
+)
+
+type binaryOperation struct {
+	locationToken *token.Token
+
+	op    string
+	left  IEvaluator
+	right IEvaluator
+}
+
+// newBinaryOperation builds the node for operator t, checking what can
+// already be checked at parse time.
+func newBinaryOperation(t *token.Token, left, right IEvaluator) (IEvaluator, *Error) {
+	if t.Value == "matches" {
+		return newMatchesOperation(t, left, right)
+	}
+	return &binaryOperation{
+		locationToken: t,
+		op:            t.Value,
+		left:          left,
+		right:         right,
+	}, nil
+}
+
+func (b *binaryOperation) GetPositionToken() *token.Token {
+	return b.locationToken
+}
+
+func (b *binaryOperation) Evaluate(target interface{}) (*Value, *Error) {
+	left, err := b.left.Evaluate(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical and coalescing operators short-circuit, the right side is
+	// only evaluated when it decides the result
+	switch b.op {
+	case "&&", "and":
+		if !left.IsTrue() {
+			return AsValue(false), nil
+		}
+		right, err := b.right.Evaluate(target)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(right.IsTrue()), nil
+	case "||", "or":
+		if left.IsTrue() {
+			return AsValue(true), nil
+		}
+		right, err := b.right.Evaluate(target)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(right.IsTrue()), nil
+	case "??":
+		// The first non nil operand wins
+		if !isNilValue(left) {
+			return left, nil
+		}
+		return b.right.Evaluate(target)
+	}
+
+	right, err := b.right.Evaluate(target)
+	if err != nil {
+		return nil, err
+	}
+
+	result, opErr := applyBinary(b.op, left, right)
+	if opErr != nil {
+		return nil, NewError(opErr.Error(), b.locationToken)
+	}
+	return result, nil
+}
+
+// maxCachedPatterns bounds the regexps a matches operation keeps around for
+// patterns only known at evaluation time.
+const maxCachedPatterns = 64
+
+type matchesOperation struct {
+	locationToken *token.Token
+
+	left    IEvaluator
+	pattern IEvaluator
+
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}
+
+func newMatchesOperation(t *token.Token, left, pattern IEvaluator) (IEvaluator, *Error) {
+	m := &matchesOperation{
+		locationToken: t,
+		left:          left,
+		pattern:       pattern,
+		compiled:      map[string]*regexp.Regexp{},
+	}
+	// Literal patterns are compiled once, right away, so that a broken
+	// pattern is reported where it is written
+	if literal, ok := pattern.(*stringResolver); ok {
+		re, err := regexp.Compile(literal.val)
+		if err != nil {
+			return nil, NewError(fmt.Sprintf("Invalid regular expression: %s", err), literal.locationToken)
+		}
+		m.compiled[literal.val] = re
+	}
+	return m, nil
+}
+
+func (m *matchesOperation) GetPositionToken() *token.Token {
+	return m.locationToken
+}
+
+func (m *matchesOperation) Evaluate(target interface{}) (*Value, *Error) {
+	left, err := m.left.Evaluate(target)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := m.pattern.Evaluate(target)
+	if err != nil {
+		return nil, err
+	}
+	if !pattern.IsString() {
+		return nil, NewError(fmt.Sprintf("Operator 'matches' expects a string pattern (not %s)", kindOf(pattern)), m.pattern.GetPositionToken())
+	}
+	if left.IsNil() {
+		return AsValue(false), nil
+	}
+
+	re, reErr := m.regexp(pattern.String())
+	if reErr != nil {
+		return nil, NewError(fmt.Sprintf("Invalid regular expression: %s", reErr), m.pattern.GetPositionToken())
+	}
+	return AsValue(re.MatchString(left.String())), nil
+}
+
+// regexp returns the compiled pattern, compiling and caching it on first use.
+func (m *matchesOperation) regexp(pattern string) (*regexp.Regexp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if re, ok := m.compiled[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.compiled) >= maxCachedPatterns {
+		m.compiled = map[string]*regexp.Regexp{}
+	}
+	m.compiled[pattern] = re
+	return re, nil
+}
+
+type unaryOperation struct {
+	locationToken *token.Token
+
+	op      string
+	operand IEvaluator
+}
+
+func (u *unaryOperation) GetPositionToken() *token.Token {
+	return u.locationToken
+}
+
+func (u *unaryOperation) Evaluate(target interface{}) (*Value, *Error) {
+	operand, err := u.operand.Evaluate(target)
+	if err != nil {
+		return nil, err
+	}
+
+	result, opErr := applyUnary(u.op, operand)
+	if opErr != nil {
+		return nil, NewError(opErr.Error(), u.locationToken)
+	}
+	return result, nil
+}
+
+type conditionalOperation struct {
+	locationToken *token.Token
+
+	cond      IEvaluator
+	then      IEvaluator
+	otherwise IEvaluator
+}
+
+func (c *conditionalOperation) GetPositionToken() *token.Token {
+	return c.locationToken
+}
+
+// Evaluate only evaluates the branch selected by the condition.
+func (c *conditionalOperation) Evaluate(target interface{}) (*Value, *Error) {
+	cond, err := c.cond.Evaluate(target)
+	if err != nil {
+		return nil, err
+	}
+	if cond.IsTrue() {
+		return c.then.Evaluate(target)
+	}
+	return c.otherwise.Evaluate(target)
+}
+
+func applyUnary(op string, operand *Value) (*Value, error) {
+	switch op {
+	case "!", "not":
+		return AsValue(!operand.IsTrue()), nil
+	case "+":
+		if !operand.IsNumber() {
+			return nil, fmt.Errorf("Operator '+' is not defined on %s", kindOf(operand))
+		}
+		return AsValue(operand.getResolvedValue().Interface()), nil
+	case "-":
+		return negateNumber(operand)
+	default:
+		return nil, fmt.Errorf("Unknown unary operator '%s'", op)
+	}
+}
+
+// negateNumber flips the sign of a number keeping its concrete type, so a
+// negated int8 field is still an int8.
+func negateNumber(operand *Value) (*Value, error) {
+	if !operand.IsNumber() {
+		return nil, fmt.Errorf("Operator '-' is not defined on %s", kindOf(operand))
+	}
+	rv := operand.getResolvedValue()
+	nv := reflect.New(rv.Type()).Elem()
+	switch numberKindOf(operand) {
+	case numberFloat:
+		nv.SetFloat(-rv.Float())
+	case numberSigned:
+		// The smallest value of a signed type has no opposite in it
+		if rv.Int() == math.MinInt64 || nv.OverflowInt(-rv.Int()) {
+			return nil, fmt.Errorf("Negation of %d overflows %s", rv.Int(), rv.Type())
+		}
+		nv.SetInt(-rv.Int())
+	default:
+		return nil, fmt.Errorf("Can not negate unsigned %s", rv.Type())
+	}
+	return &Value{val: nv}, nil
+}
+
+func applyBinary(op string, left, right *Value) (*Value, error) {
+	switch op {
+	case "==":
+		return AsValue(valuesEqual(left, right)), nil
+	case "!=":
+		return AsValue(!valuesEqual(left, right)), nil
+	case "in":
+		return AsValue(right.Contains(left)), nil
+	case "not in":
+		return AsValue(!right.Contains(left)), nil
+	case "<", ">", "<=", ">=":
+		c, err := compareValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<":
+			return AsValue(c < 0), nil
+		case ">":
+			return AsValue(c > 0), nil
+		case "<=":
+			return AsValue(c <= 0), nil
+		default:
+			return AsValue(c >= 0), nil
+		}
+	case "+":
+		if left.IsString() && right.IsString() {
+			return AsValue(left.String() + right.String()), nil
+		}
+		return arithmetic(op, left, right)
+	case "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+		return arithmetic(op, left, right)
+	default:
+		return nil, fmt.Errorf("Unknown binary operator '%s'", op)
+	}
+}
+
+// numberKind classifies numeric values so that operands of different kinds
+// can be promoted to a common representation.
+type numberKind int
+
+const (
+	numberSigned numberKind = iota
+	numberUnsigned
+	numberFloat
+)
+
+func numberKindOf(v *Value) numberKind {
+	switch v.getResolvedValue().Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return numberUnsigned
+	case reflect.Float32, reflect.Float64:
+		return numberFloat
+	default:
+		return numberSigned
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 comparing two numeric values. Mixed
+// signed and unsigned operands are compared without overflow.
+func compareNumbers(left, right *Value) int {
+	lk, rk := numberKindOf(left), numberKindOf(right)
+	switch {
+	case lk == numberFloat || rk == numberFloat:
+		l, r := left.Float(), right.Float()
+		if isFloat32(left) || isFloat32(right) {
+			// Compare at float32 precision, else a float32 field holding
+			// 9.99 would never equal the literal 9.99
+			l, r = float64(float32(l)), float64(float32(r))
+		}
+		return compareFloat(l, r)
+	case lk == numberSigned && rk == numberSigned:
+		l, r := left.getResolvedValue().Int(), right.getResolvedValue().Int()
+		return compareInt(l, r)
+	case lk == numberUnsigned && rk == numberUnsigned:
+		l, r := left.getResolvedValue().Uint(), right.getResolvedValue().Uint()
+		return compareUint(l, r)
+	case lk == numberSigned:
+		l := left.getResolvedValue().Int()
+		if l < 0 {
+			return -1
+		}
+		return compareUint(uint64(l), right.getResolvedValue().Uint())
+	default:
+		r := right.getResolvedValue().Int()
+		if r < 0 {
+			return 1
+		}
+		return compareUint(left.getResolvedValue().Uint(), uint64(r))
+	}
+}
+
+func isFloat32(v *Value) bool {
+	return v.getResolvedValue().Kind() == reflect.Float32
+}
+
+func compareFloat(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func compareInt(l, r int64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func compareUint(l, r uint64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// compareValues orders numbers numerically and strings lexically.
+func compareValues(left, right *Value) (int, error) {
+	if left.IsNumber() && right.IsNumber() {
+		return compareNumbers(left, right), nil
+	}
+	if left.IsString() && right.IsString() {
+		return strings.Compare(left.String(), right.String()), nil
+	}
+	return 0, fmt.Errorf("Can not compare %s with %s", kindOf(left), kindOf(right))
+}
+
+func valuesEqual(left, right *Value) bool {
+	if left.IsNumber() && right.IsNumber() {
+		return compareNumbers(left, right) == 0
+	}
+	if isNilValue(left) || isNilValue(right) {
+		return isNilValue(left) && isNilValue(right)
+	}
+	lv, rv := left.getResolvedValue(), right.getResolvedValue()
+	if lv.Type() != rv.Type() {
+		return false
+	}
+	if lv.Type().Comparable() {
+		return lv.Interface() == rv.Interface()
+	}
+	return reflect.DeepEqual(lv.Interface(), rv.Interface())
+}
+
+var intType = reflect.TypeOf(0)
+
+// arithmetic applies the arithmetic and bitwise operators to numbers.
+// Floats win over integers, integer operations keep the type of their
+// operands as far as possible, see integerResultType.
+func arithmetic(op string, left, right *Value) (*Value, error) {
+	if !left.IsNumber() || !right.IsNumber() {
+		return nil, fmt.Errorf("Operator '%s' is not defined on %s and %s", op, kindOf(left), kindOf(right))
+	}
+
+	isFloat := numberKindOf(left) == numberFloat || numberKindOf(right) == numberFloat
+	if op == "**" && !isFloat && compareNumbers(right, AsValue(0)) < 0 {
+		// A negative exponent can't give an integer
+		isFloat = true
+	}
+	if isFloat {
+		return floatArithmetic(op, left.Float(), right.Float())
+	}
+	return integerArithmetic(op, left, right)
+}
+
+func floatArithmetic(op string, l, r float64) (*Value, error) {
+	switch op {
+	case "+":
+		return AsValue(l + r), nil
+	case "-":
+		return AsValue(l - r), nil
+	case "*":
+		return AsValue(l * r), nil
+	case "/":
+		return AsValue(l / r), nil
+	case "%":
+		return AsValue(math.Mod(l, r)), nil
+	case "**":
+		return AsValue(math.Pow(l, r)), nil
+	default:
+		return nil, fmt.Errorf("Operator '%s' is not defined on float", op)
+	}
+}
+
+// integerResultType picks the type of an integer operation. Operands of the
+// same type keep it and a plain int, the type of integer literals, adopts
+// the type of the other side, so "Flags & 4" stays the type of Flags.
+// Shifts always have the type of their left operand.
+func integerResultType(op string, left, right reflect.Type) reflect.Type {
+	switch {
+	case op == "<<" || op == ">>" || left == right:
+		return left
+	case left == intType:
+		return right
+	case right == intType:
+		return left
+	case isUnsignedKind(left.Kind()) && isUnsignedKind(right.Kind()):
+		return reflect.TypeOf(uint64(0))
+	default:
+		return intType
+	}
+}
+
+func integerArithmetic(op string, left, right *Value) (*Value, error) {
+	resultType := integerResultType(op, left.getResolvedValue().Type(), right.getResolvedValue().Type())
+	result := reflect.New(resultType).Elem()
+
+	if op == "<<" || op == ">>" {
+		if compareNumbers(right, AsValue(0)) < 0 {
+			return nil, fmt.Errorf("Negative shift count %s", right.String())
+		}
+	}
+
+	if isUnsignedKind(resultType.Kind()) {
+		l, lok := uint64Of(left)
+		r, rok := uint64Of(right)
+		if !lok || !rok {
+			return nil, fmt.Errorf("Operator '%s' mixes a negative number with %s", op, resultType)
+		}
+		n, err := uintOperation(op, l, r)
+		if err == errOverflow || (err == nil && result.OverflowUint(n)) {
+			return nil, fmt.Errorf("Result of '%s' overflows %s", op, resultType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.SetUint(n)
+		return &Value{val: result}, nil
+	}
+
+	l, lok := int64Of(left)
+	r, rok := int64Of(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("Operand of '%s' overflows %s", op, resultType)
+	}
+	n, err := intOperation(op, l, r)
+	if err == errOverflow || (err == nil && result.OverflowInt(n)) {
+		return nil, fmt.Errorf("Result of '%s' overflows %s", op, resultType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.SetInt(n)
+	return &Value{val: result}, nil
+}
+
+// errOverflow is returned by the 64-bit operations whose result doesn't
+// fit in 64 bits, the narrower types are checked by their caller.
+var errOverflow = errors.New("overflow")
+
+func uintOperation(op string, l, r uint64) (uint64, error) {
+	switch op {
+	case "+":
+		n, carry := bits.Add64(l, r, 0)
+		if carry != 0 {
+			return 0, errOverflow
+		}
+		return n, nil
+	case "-":
+		if r > l {
+			return 0, fmt.Errorf("Result of '-' is negative for unsigned operands")
+		}
+		return l - r, nil
+	case "*":
+		return mulUint64(l, r)
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("Division by zero")
+		}
+		if op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	case "&":
+		return l & r, nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "<<":
+		if l != 0 && (r >= 64 || (l<<r)>>r != l) {
+			return 0, errOverflow
+		}
+		return l << r, nil
+	case ">>":
+		return l >> r, nil
+	case "**":
+		// Exponentiation by squaring, so that a big exponent either
+		// overflows or is done in a few steps
+		n := uint64(1)
+		for ; r > 0; r >>= 1 {
+			var err error
+			if r&1 == 1 {
+				if n, err = mulUint64(n, l); err != nil {
+					return 0, err
+				}
+			}
+			if r > 1 {
+				if l, err = mulUint64(l, l); err != nil {
+					return 0, err
+				}
+			}
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("Unknown binary operator '%s'", op)
+	}
+}
+
+func intOperation(op string, l, r int64) (int64, error) {
+	switch op {
+	case "+":
+		n := l + r
+		if (n^l)&(n^r) < 0 {
+			// The operands have the same sign, the result hasn't
+			return 0, errOverflow
+		}
+		return n, nil
+	case "-":
+		n := l - r
+		if (l^r)&(n^l) < 0 {
+			return 0, errOverflow
+		}
+		return n, nil
+	case "*":
+		return mulInt64(l, r)
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("Division by zero")
+		}
+		if op == "/" {
+			if l == math.MinInt64 && r == -1 {
+				return 0, errOverflow
+			}
+			return l / r, nil
+		}
+		return l % r, nil
+	case "&":
+		return l & r, nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "<<":
+		if l != 0 && (r >= 64 || (l<<uint64(r))>>uint64(r) != l) {
+			return 0, errOverflow
+		}
+		return l << uint64(r), nil
+	case ">>":
+		return l >> uint64(r), nil
+	case "**":
+		// Negative exponents are float operations, see arithmetic
+		n := int64(1)
+		for ; r > 0; r >>= 1 {
+			var err error
+			if r&1 == 1 {
+				if n, err = mulInt64(n, l); err != nil {
+					return 0, err
+				}
+			}
+			if r > 1 {
+				if l, err = mulInt64(l, l); err != nil {
+					return 0, err
+				}
+			}
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("Unknown binary operator '%s'", op)
+	}
+}
+
+// mulUint64 multiplies l and r, errOverflow when the product doesn't fit.
+func mulUint64(l, r uint64) (uint64, error) {
+	hi, lo := bits.Mul64(l, r)
+	if hi != 0 {
+		return 0, errOverflow
+	}
+	return lo, nil
+}
+
+// mulInt64 multiplies l and r, errOverflow when the product doesn't fit.
+func mulInt64(l, r int64) (int64, error) {
+	n := l * r
+	if l != 0 && (n/l != r || (l == -1 && r == math.MinInt64)) {
+		return 0, errOverflow
+	}
+	return n, nil
+}
+
+func isUnsignedKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// int64Of returns an integer value as int64, false when it doesn't fit.
+func int64Of(v *Value) (int64, bool) {
+	if numberKindOf(v) == numberUnsigned {
+		n := v.getResolvedValue().Uint()
+		return int64(n), n <= math.MaxInt64
+	}
+	return v.getResolvedValue().Int(), true
+}
+
+// uint64Of returns an integer value as uint64, false when it is negative.
+func uint64Of(v *Value) (uint64, bool) {
+	if numberKindOf(v) == numberUnsigned {
+		return v.getResolvedValue().Uint(), true
+	}
+	n := v.getResolvedValue().Int()
+	return uint64(n), n >= 0
+}
+
+// isNilValue reports whether v is nil the way Go sees it: no value at all,
+// or a nil pointer, slice, map, interface, func or chan.
+func isNilValue(v *Value) bool {
+	if v.IsNil() {
+		return true
+	}
+	rv := v.val
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func kindOf(v *Value) string {
+	if v.IsNil() {
+		return "nil"
+	}
+	return v.getResolvedValue().Kind().String()
+}
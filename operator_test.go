@@ -0,0 +1,463 @@
+package el_test
+
+import (
+	"testing"
+
+	el "github.com/runcom/el"
+	"github.com/stretchr/testify/assert"
+)
+
+type Stats struct {
+	Title  string
+	Views  uint32
+	Score  float32
+	Delta  int8
+	Weight float64
+	Tags   []string
+
+	Published bool
+	Flags     uint32
+	Price     float32
+}
+
+func (s Stats) IsPublished() bool {
+	return s.Published
+}
+
+func TestBinaryOperators(t *testing.T) {
+	s := &Stats{
+		Title:  "Blog",
+		Views:  10,
+		Score:  2.5,
+		Delta:  -3,
+		Weight: 0.5,
+		Tags:   []string{"a"},
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`1 + 2 * 3`, 7},
+		{`(1 + 2) * 3`, 9},
+		{`10 - 4 - 3`, 3},
+		{`12 / 4 / 3`, 1},
+		{`Views + 5`, uint32(15)},
+		{`Views - Delta`, 13},
+		{`Score * 2`, float64(5)},
+		{`Views / 4`, uint32(2)},
+		{`Weight + Views`, 10.5},
+		{`Title + " title"`, "Blog title"},
+		{`Views > 2 && Title != ""`, true},
+		{`Views < 2 || Title == "Blog"`, true},
+		{`Delta < Views`, true},
+		{`Views >= 10`, true},
+		{`Views <= 9`, false},
+		{`Score * 2 == 5`, true},
+		{`Title < "Car"`, true},
+		{`Title >= "Blog"`, true},
+		{`1 == 1 == true`, true},
+		{`Delta + 3 != 0 || Views == 10`, true},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(s)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+}
+
+func TestBinaryOperatorsShortCircuit(t *testing.T) {
+	s := &Stats{}
+
+	// The right hand side would fail, it must not be evaluated
+	exp := el.Expression(`Views > 0 && Tags[5] == "x"`)
+	v, err := exp.Execute(s)
+	assert.NoError(t, err)
+	assert.False(t, v.Bool())
+
+	exp = el.Expression(`Views == 0 || Tags[5] == "x"`)
+	v, err = exp.Execute(s)
+	assert.NoError(t, err)
+	assert.True(t, v.Bool())
+}
+
+func TestBinaryOperatorsErrors(t *testing.T) {
+	s := &Stats{Title: "Blog"}
+
+	for _, e := range []string{
+		`Views / 0`,
+		`Title * 2`,
+		`Title < 2`,
+		`Views +`,
+	} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(s)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestUnaryOperators(t *testing.T) {
+	s := &Stats{
+		Title: "Blog",
+		Views: 10,
+		Score: 2.5,
+		Delta: -3,
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`!IsPublished()`, true},
+		{`!!IsPublished()`, false},
+		{`!Title`, false},
+		{`!Weight`, true},
+		{`-Delta`, int8(3)},
+		{`-Score`, float32(-2.5)},
+		{`-Weight`, float64(0)},
+		{`+Delta`, int8(-3)},
+		{`-3`, -3},
+		{`- -3`, 3},
+		{`-2 * 3`, -6},
+		{`2 - -3`, 5},
+		{`!IsPublished() && Views > 1`, true},
+		{`!Views == false`, true},
+		{`-Delta + 1 == 4`, true},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(s)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	for _, e := range []string{`-Views`, `-Title`, `+Tags`, `!`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(s)
+		assert.Error(t, err, e)
+	}
+
+	// The smallest value of a signed type has no opposite in it
+	min := &Stats{Delta: -128}
+	for _, e := range []string{`-Delta`, `-(-9223372036854775807 - 1)`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(min)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestConditionalOperator(t *testing.T) {
+	s := &Stats{
+		Title: "Blog",
+		Views: 10,
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`Title != "" ? Title : "anonymous"`, "Blog"},
+		{`IsPublished() ? Title : "draft"`, "draft"},
+		{`Views > 5 ? "hot" : Views > 0 ? "warm" : "cold"`, "hot"},
+		{`Views > 50 ? "hot" : Views > 0 ? "warm" : "cold"`, "warm"},
+		{`(Views > 5 ? 1 : 2) + 1`, 2},
+		{`Tags ? Tags[0] : "none"`, "none"},
+		{`Views ? (IsPublished() ? 1 : 2) : 3`, 2},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(s)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	for _, e := range []string{`Views ? 1`, `Views ? 1 :`, `? 1 : 2`} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(s)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestMembershipOperators(t *testing.T) {
+	b := &Blog{
+		Title:      "Blog title1",
+		RoleState:  map[string]uint{"100": 1},
+		CommentIds: []uint64{1, 3},
+		Comments: map[string]*Comment{
+			"1": {NickName: "u1"},
+		},
+	}
+	s := &Stats{Tags: []string{"admin", "editor"}}
+
+	cases := []struct {
+		target   interface{}
+		exp      string
+		expected bool
+	}{
+		{s, `"admin" in Tags`, true},
+		{s, `"root" in Tags`, false},
+		{s, `"root" not in Tags`, true},
+		{s, `"Tags" in Tags`, false},
+		{b, `3 in CommentIds`, true},
+		{b, `2 in CommentIds`, false},
+		{b, `3 not in CommentIds`, false},
+		{b, `-1 in CommentIds`, false},
+		{b, `"100" in RoleState`, true},
+		{b, `100 in RoleState`, true},
+		{b, `101 in RoleState`, false},
+		{b, `CommentIds[0] in Comments`, true},
+		{b, `"title" in Title`, true},
+		{b, `"Title" in Comments["1"]`, false},
+		{b, `"NickName" in Comments["1"]`, true},
+		{b, `1 + 2 in CommentIds && "x" not in Title`, true},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(c.target)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+}
+
+func TestMatchesOperator(t *testing.T) {
+	s := &Stats{
+		Title: "A42",
+		Views: 100,
+		Tags:  []string{`^A\d+$`, `(`},
+	}
+
+	cases := []struct {
+		exp      string
+		expected bool
+	}{
+		{`Title matches "^A[0-9]+$"`, true},
+		{`Title matches "^B"`, false},
+		{`Title matches Tags[0]`, true},
+		{`Views matches "^1"`, true},
+		{`!(Title matches "^B") && Title matches "2$"`, true},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(s)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	exp := el.Expression(`Title matches "[a-"`)
+	_, err := exp.Execute(s)
+	if assert.Error(t, err) {
+		elErr, ok := err.(*el.Error)
+		if assert.True(t, ok) {
+			assert.Equal(t, 15, elErr.Cursor)
+			assert.Equal(t, "[a-", elErr.Token.Value)
+		}
+	}
+
+	exp = el.Expression(`Title matches Tags[1]`)
+	_, err = exp.Execute(s)
+	assert.Error(t, err)
+
+	exp = el.Expression(`Title matches Views`)
+	_, err = exp.Execute(s)
+	assert.Error(t, err)
+}
+
+type Rule struct {
+	Notes string
+	Order int
+}
+
+func TestWordLogicalOperators(t *testing.T) {
+	s := &Stats{Title: "Blog", Views: 10}
+	r := &Rule{Notes: "n", Order: 2}
+
+	cases := []struct {
+		target   interface{}
+		exp      string
+		expected bool
+	}{
+		{s, `Views > 5 and Title == "Blog"`, true},
+		{s, `Views > 50 or Title == "Blog"`, true},
+		{s, `not IsPublished()`, true},
+		{s, `not(IsPublished()) and not (Views == 0)`, true},
+		{s, `Views > 50 or Views > 5 and Title == "x"`, false},
+		{s, `(Views > 50 or Views > 5) and Title == "Blog"`, true},
+		{s, `"x" not in Tags and not Tags`, true},
+		{r, `Notes == "n" and Order == 2`, true},
+		{r, `not Notes or Order > 5`, false},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(c.target)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	s := &Stats{
+		Views: 10,
+		Delta: -3,
+		Flags: 4 | 1,
+	}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`Flags & 4 != 0`, true},
+		{`Flags & 2 != 0`, false},
+		{`Flags & 4`, uint32(4)},
+		{`Flags | 2`, uint32(7)},
+		{`Flags ^ 1`, uint32(4)},
+		{`Flags << 2`, uint32(20)},
+		{`Flags >> 2`, uint32(1)},
+		{`1 << 3 | 1`, 9},
+		{`Views % 4`, uint32(2)},
+		{`Delta % 2`, int8(-1)},
+		{`2 ** 10`, 1024},
+		{`2 ** 3 ** 2`, 512},
+		{`2 ** -1`, 0.5},
+		{`Score ** 2`, float64(0)},
+		{`7 % 4 * 2`, 6},
+		{`Flags & 1 == 1 and Flags & 4 == 4`, true},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(s)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	for _, e := range []string{
+		`Flags - 6`,
+		`Flags & -1`,
+		`Delta * 100`,
+		`Views % 0`,
+		`1 << -1`,
+		`Score & 1`,
+	} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(s)
+		assert.Error(t, err, e)
+	}
+}
+
+type Counters struct {
+	Big   int64
+	Total uint64
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	c := &Counters{Big: 1 << 62, Total: 1 << 63}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`9223372036854775806 + 1`, 9223372036854775807},
+		{`-9223372036854775807 - 1`, -9223372036854775807 - 1},
+		{`Big - 1 + Big`, int64(1<<63 - 1)},
+		{`Total + (Total - 1)`, uint64(1<<64 - 1)},
+		{`2 ** 62`, 1 << 62},
+		{`(-2) ** 63`, -1 << 63},
+		{`1 ** 9000000000000000000`, 1},
+		{`(-1) ** 9000000000000000001`, -1},
+		{`0 ** 9000000000000000000`, 0},
+		{`Total >> 63 << 63`, uint64(1 << 63)},
+	}
+	for _, cs := range cases {
+		exp := el.Expression(cs.exp)
+		v, err := exp.Execute(c)
+		if assert.NoError(t, err, cs.exp) {
+			assert.Equal(t, cs.expected, v.Interface(), cs.exp)
+		}
+	}
+
+	for _, e := range []string{
+		`9223372036854775807 + 1`,
+		`-9223372036854775807 - 2`,
+		`Big * 4`,
+		`Big * 2`,
+		`Total + Total`,
+		`Total * 2`,
+		`2 ** 64`,
+		`2 ** 63`,
+		`2 ** 9000000000000000000`,
+		`Total ** 2`,
+		`1 << 64`,
+		`Big << 1`,
+		`Total << 1`,
+		`(-9223372036854775807 - 1) / -1`,
+	} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(c)
+		if assert.Error(t, err, e) {
+			assert.Contains(t, err.Error(), "overflows", e)
+		}
+	}
+}
+
+func TestNumericLiterals(t *testing.T) {
+	s := &Stats{Score: 2.5, Price: 9.99, Views: 255}
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		{`9.99`, 9.99},
+		{`1e6`, float64(1000000)},
+		{`2.5E-1`, 0.25},
+		{`0xFF`, 255},
+		{`0x_ff`, nil},
+		{`1_000 + 1`, 1001},
+		{`1_000.000_1`, 1000.0001},
+		{`Score == 2.5`, true},
+		{`Price == 9.99`, true},
+		{`Price > 9.99`, false},
+		{`Price >= 9.99`, true},
+		{`Price < 10`, true},
+		{`Views == 0xff`, true},
+		{`Views / 2.0`, 127.5},
+	}
+
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(s)
+		if c.expected == nil {
+			assert.Error(t, err, c.exp)
+			continue
+		}
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	exp := el.Expression(`Views > 99999999999999999999`)
+	_, err := exp.Execute(s)
+	if assert.Error(t, err) {
+		elErr, ok := err.(*el.Error)
+		if assert.True(t, ok) {
+			assert.Equal(t, 9, elErr.Cursor)
+		}
+	}
+
+	exp = el.Expression(`1e400`)
+	_, err = exp.Execute(s)
+	assert.Error(t, err)
+}
@@ -1,5 +1,7 @@
 package el
 
+import "github.com/runcom/el/token"
+
 type AssociativityType int
 
 const (
@@ -18,17 +20,16 @@ var BinaryOperators map[string]Operator
 
 func init() {
 	UnaryOperators = make(map[string]Operator)
-	//UnaryOperators["not"] = Operator{Precedence: 50, Associativity: NoAssociativity}
+	UnaryOperators["not"] = Operator{Precedence: 50, Associativity: NoAssociativity}
 	UnaryOperators["!"] = Operator{Precedence: 50, Associativity: NoAssociativity}
 	UnaryOperators["-"] = Operator{Precedence: 500, Associativity: NoAssociativity}
 	UnaryOperators["+"] = Operator{Precedence: 500, Associativity: NoAssociativity}
 
-	// TODO(support bitwise operators also)
-
 	BinaryOperators = make(map[string]Operator)
-	//BinaryOperators["or"] = Operator{Precedence: 10, Associativity: AssociativityOpLeft}
+	BinaryOperators["??"] = Operator{Precedence: 5, Associativity: AssociativityOpRigth}
+	BinaryOperators["or"] = Operator{Precedence: 10, Associativity: AssociativityOpLeft}
 	BinaryOperators["||"] = Operator{Precedence: 10, Associativity: AssociativityOpLeft}
-	//BinaryOperators["and"] = Operator{Precedence: 15, Associativity: AssociativityOpLeft}
+	BinaryOperators["and"] = Operator{Precedence: 15, Associativity: AssociativityOpLeft}
 	BinaryOperators["&&"] = Operator{Precedence: 15, Associativity: AssociativityOpLeft}
 	BinaryOperators["=="] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
 	BinaryOperators["!="] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
@@ -36,26 +37,34 @@ func init() {
 	BinaryOperators[">"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
 	BinaryOperators["<="] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
 	BinaryOperators[">="] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
-	//BinaryOperators["in"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
-	//BinaryOperators["not in"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
+	BinaryOperators["in"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
+	BinaryOperators["not in"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
 	// either "matches" or tilde (~) for regexp
-	//BinaryOperators["matches"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
+	BinaryOperators["matches"] = Operator{Precedence: 20, Associativity: AssociativityOpLeft}
+	// bitwise operators bind like in Go: "|" and "^" as additions, "&" and
+	// shifts as multiplications, so "Flags & 4 != 0" needs no parentheses
 	BinaryOperators["+"] = Operator{Precedence: 30, Associativity: AssociativityOpLeft}
 	BinaryOperators["-"] = Operator{Precedence: 30, Associativity: AssociativityOpLeft}
+	BinaryOperators["|"] = Operator{Precedence: 30, Associativity: AssociativityOpLeft}
+	BinaryOperators["^"] = Operator{Precedence: 30, Associativity: AssociativityOpLeft}
 	BinaryOperators["*"] = Operator{Precedence: 60, Associativity: AssociativityOpLeft}
 	BinaryOperators["/"] = Operator{Precedence: 60, Associativity: AssociativityOpLeft}
+	BinaryOperators["%"] = Operator{Precedence: 60, Associativity: AssociativityOpLeft}
+	BinaryOperators["&"] = Operator{Precedence: 60, Associativity: AssociativityOpLeft}
+	BinaryOperators["<<"] = Operator{Precedence: 60, Associativity: AssociativityOpLeft}
+	BinaryOperators[">>"] = Operator{Precedence: 60, Associativity: AssociativityOpLeft}
+	BinaryOperators["**"] = Operator{Precedence: 200, Associativity: AssociativityOpRigth}
 }
 
 type Parser struct {
-	idx       int
-	tokens    []*Token
-	lastToken *Token
+	stream    *token.TokenStream
+	lastToken *token.Token
 }
 
-func NewParser(tokens []*Token) *Parser {
-	p := &Parser{tokens: tokens}
-	if len(tokens) > 0 {
-		p.lastToken = tokens[len(tokens)-1]
+func NewParser(stream *token.TokenStream) *Parser {
+	p := &Parser{stream: stream}
+	if stream.Size() > 0 {
+		p.lastToken = &stream.Tokens[stream.Size()-1]
 	}
 	return p
 }
@@ -65,14 +74,20 @@ func (p *Parser) Consume() {
 }
 
 func (p *Parser) ConsumeN(count int) {
-	p.idx += count
+	for i := 0; i < count; i++ {
+		if p.stream.Next() != nil {
+			return
+		}
+	}
 }
 
-func (p *Parser) Current() *Token {
-	return p.Get(p.idx)
+// Current returns the token under the cursor, at the end of the stream
+// this is the EOF token.
+func (p *Parser) Current() *token.Token {
+	return p.Get(p.stream.Position)
 }
 
-func (p *Parser) MatchType(typ TokenType) *Token {
+func (p *Parser) MatchType(typ token.Type) *token.Token {
 	if t := p.PeekType(typ); t != nil {
 		p.Consume()
 		return t
@@ -80,7 +95,7 @@ func (p *Parser) MatchType(typ TokenType) *Token {
 	return nil
 }
 
-func (p *Parser) Match(typ TokenType, val string) *Token {
+func (p *Parser) Match(typ token.Type, val string) *token.Token {
 	if t := p.Peek(typ, val); t != nil {
 		p.Consume()
 		return t
@@ -88,7 +103,7 @@ func (p *Parser) Match(typ TokenType, val string) *Token {
 	return nil
 }
 
-func (p *Parser) MatchOne(typ TokenType, vals ...string) *Token {
+func (p *Parser) MatchOne(typ token.Type, vals ...string) *token.Token {
 	for _, val := range vals {
 		if t := p.Peek(typ, val); t != nil {
 			p.Consume()
@@ -98,15 +113,15 @@ func (p *Parser) MatchOne(typ TokenType, vals ...string) *Token {
 	return nil
 }
 
-func (p *Parser) PeekType(typ TokenType) *Token {
+func (p *Parser) PeekType(typ token.Type) *token.Token {
 	return p.PeekTypeN(0, typ)
 }
 
-func (p *Parser) Peek(typ TokenType, val string) *Token {
+func (p *Parser) Peek(typ token.Type, val string) *token.Token {
 	return p.PeekN(0, typ, val)
 }
 
-func (p *Parser) PeekOne(typ TokenType, vals ...string) *Token {
+func (p *Parser) PeekOne(typ token.Type, vals ...string) *token.Token {
 	for _, v := range vals {
 		t := p.PeekN(0, typ, v)
 		if t != nil {
@@ -116,66 +131,60 @@ func (p *Parser) PeekOne(typ TokenType, vals ...string) *Token {
 	return nil
 }
 
-func (p *Parser) PeekN(shift int, typ TokenType, val string) *Token {
-	t := p.Get(p.idx + shift)
+func (p *Parser) PeekN(shift int, typ token.Type, val string) *token.Token {
+	t := p.Get(p.stream.Position + shift)
 	if t != nil {
-		if t.Typ == typ && t.Val == val {
+		if t.Test(typ, val) {
 			return t
 		}
 	}
 	return nil
 }
 
-func (p *Parser) PeekTypeN(shift int, typ TokenType) *Token {
-	t := p.Get(p.idx + shift)
+func (p *Parser) PeekTypeN(shift int, typ token.Type) *token.Token {
+	t := p.Get(p.stream.Position + shift)
 	if t != nil {
-		if t.Typ == typ {
+		if t.Test(typ) {
 			return t
 		}
 	}
 	return nil
 }
 
+// Remaining returns the number of tokens left before EOF.
 func (p *Parser) Remaining() int {
-	return len(p.tokens) - p.idx
+	return p.stream.Size() - p.stream.Position - 1
 }
 
 func (p *Parser) Count() int {
-	return len(p.tokens)
+	return p.stream.Size()
 }
 
-func (p *Parser) Get(i int) *Token {
-	if i < len(p.tokens) {
-		return p.tokens[i]
+func (p *Parser) Get(i int) *token.Token {
+	if i >= 0 && i < p.stream.Size() {
+		return &p.stream.Tokens[i]
 	}
 	return nil
 }
 
-func (p *Parser) GetR(shift int) *Token {
-	i := p.idx + shift
+func (p *Parser) GetR(shift int) *token.Token {
+	i := p.stream.Position + shift
 	return p.Get(i)
 }
 
-func (p *Parser) Error(msg string, token *Token) *Error {
-	if token == nil {
+// EOF reports whether the whole stream has been consumed.
+func (p *Parser) EOF() bool {
+	return p.stream.EOF()
+}
+
+func (p *Parser) Error(msg string, tok *token.Token) *Error {
+	if tok == nil {
 		// Set current token
-		token = p.Current()
-		if token == nil {
+		tok = p.Current()
+		if tok == nil {
 			// Set to last token
-			if len(p.tokens) > 0 {
-				token = p.tokens[len(p.tokens)-1]
-			}
+			tok = p.lastToken
 		}
 	}
-	var line, col int
-	if token != nil {
-		line = token.Line
-		col = token.Col
-	}
-	return &Error{
-		Line:     line,
-		Column:   col,
-		Token:    token,
-		ErrorMsg: msg,
-	}
+	return NewError(msg, tok)
 }
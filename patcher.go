@@ -6,27 +6,39 @@ import "fmt"
 type Patch map[Expression]interface{}
 
 // Patcher use to patch in memory struct with path
-type Patcher struct{}
+type Patcher struct {
+	// Env holds the functions the paths can call, it may be nil
+	Env *Env
+}
 
 // PatchIt do patch work
 func (p *Patcher) PatchIt(target interface{}, patch Patch) error {
 
 	for path, value := range patch {
 
-		targetValue, err := path.Execute(target)
+		// Paths are compiled once, patches tend to use the same ones
+		prog, err := patchPrograms.compile(string(path))
 		if err != nil {
 			return err
 		}
 
-		if targetValue.IsNil() && targetValue.keySetter == nil {
-			return fmt.Errorf("path: %s doesn't match any property in target", path)
-		}
-
-		err = targetValue.SetValue(value)
+		// A path with a wildcard patches every value it reaches
+		targetValues, err := prog.EvalAllEnv(p.Env, target)
 		if err != nil {
 			return err
 		}
 
+		for _, targetValue := range targetValues {
+			if !targetValue.val.IsValid() && targetValue.keySetter == nil {
+				return fmt.Errorf("path: %s doesn't match any property in target", path)
+			}
+
+			err = targetValue.SetValue(value)
+			if err != nil {
+				return err
+			}
+		}
+
 	}
 
 	return nil
@@ -1,10 +1,11 @@
 package el_test
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
-	p "github.com/runcom/go-el"
+	p "github.com/runcom/el"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,3 +67,58 @@ func TestDoPatch(t *testing.T) {
 	assert.Equal(uint(100), b.RoleState["100"])
 
 }
+
+func TestPatchFloat32(t *testing.T) {
+	assert := assert.New(t)
+	patcher := p.Patcher{}
+	prices := &struct {
+		Price  float32
+		ByName map[string]float32
+	}{ByName: map[string]float32{}}
+
+	err := patcher.PatchIt(prices, p.Patch{
+		"price":         json.Number("9.99"),
+		"byName[\"a\"]": json.Number("1.5"),
+	})
+	assert.NoError(err)
+	assert.Equal(float32(9.99), prices.Price)
+	assert.Equal(float32(1.5), prices.ByName["a"])
+}
+
+func TestPatchLiteralValues(t *testing.T) {
+	assert := assert.New(t)
+	patcher := p.Patcher{}
+	b := &Blog{RoleState: map[string]uint{}}
+
+	exp := p.Expression(`[1, 2, 3]`)
+	ids, err := exp.Execute(nil)
+	assert.NoError(err)
+
+	err = patcher.PatchIt(b, p.Patch{
+		"commentIds": ids,
+		"roleState":  map[string]interface{}{"100": 1, "200": json.Number("2")},
+	})
+	assert.NoError(err)
+	assert.Equal([]uint64{1, 2, 3}, b.CommentIds)
+	assert.Equal(map[string]uint{"100": 1, "200": 2}, b.RoleState)
+}
+
+func TestPatchWildcard(t *testing.T) {
+	assert := assert.New(t)
+	patcher := p.Patcher{}
+	u := &User{
+		Images: []*Image{{"1.jpg"}, {"2.jpg"}},
+		ImgIdx: map[string]*Image{"1": {"1.jpg"}, "2": {"2.jpg"}},
+	}
+
+	err := patcher.PatchIt(u, p.Patch{
+		"images[*].content": "",
+		"imgIdx[*]":         nil,
+		"imgIDList[*]":      1,
+	})
+	assert.NoError(err)
+	assert.Equal("", u.Images[0].Content)
+	assert.Equal("", u.Images[1].Content)
+	assert.Empty(u.ImgIdx)
+	assert.Empty(u.ImgIDList)
+}
@@ -0,0 +1,151 @@
+package el
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+
+	"github.com/runcom/el/lexer"
+)
+
+// Program is a compiled expression. It is never modified once compiled, so
+// it can be evaluated many times and by many goroutines.
+type Program struct {
+	source string
+	root   IEvaluator
+	typ    reflect.Type // nil unless checked by CompileFor
+}
+
+// Compile tokenizes and parses expr once, for it to be evaluated many
+// times.
+func Compile(expr string) (*Program, error) {
+
+	stream, err := lexer.Tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := NewParser(stream)
+
+	root, perr := parser.ParseExp()
+	if perr != nil {
+		perr.Expression = expr
+		return nil, perr
+	}
+	if !parser.EOF() {
+		perr = parser.Error("Unexpected token after end of expression.", nil)
+		perr.Expression = expr
+		return nil, perr
+	}
+
+	return &Program{source: expr, root: root}, nil
+}
+
+// String returns the source of the program.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Type returns the type the program evaluates to, as found by CompileFor.
+// It is nil when only known at run time, and for programs from Compile.
+func (p *Program) Type() reflect.Type {
+	return p.typ
+}
+
+// Eval evaluates the program against target.
+func (p *Program) Eval(target interface{}) (*Value, error) {
+	return p.EvalEnv(nil, target)
+}
+
+// EvalEnv evaluates the program against target in env, so it can call
+// the functions and refer to the variables of env.
+func (p *Program) EvalEnv(env *Env, target interface{}) (*Value, error) {
+	value, perr := p.root.Evaluate(&scope{target: target, env: env})
+	if perr != nil {
+		perr.Expression = p.source
+		return nil, perr
+	}
+	return value, nil
+}
+
+// EvalAll returns every value the program reaches in target, see
+// Expression.ExecuteAll.
+func (p *Program) EvalAll(target interface{}) ([]*Value, error) {
+	return p.EvalAllEnv(nil, target)
+}
+
+// EvalAllEnv is EvalAll in env.
+func (p *Program) EvalAllEnv(env *Env, target interface{}) ([]*Value, error) {
+	sc := &scope{target: target, env: env}
+
+	vr, ok := p.root.(*variableResolver)
+	if !ok {
+		value, perr := p.root.Evaluate(sc)
+		if perr != nil {
+			perr.Expression = p.source
+			return nil, perr
+		}
+		return []*Value{value}, nil
+	}
+
+	values, perr := vr.EvaluateAll(sc)
+	if perr != nil {
+		perr.Expression = p.source
+		return nil, perr
+	}
+	return values, nil
+}
+
+// maxCachedPrograms bounds the programs kept by the Patcher.
+const maxCachedPrograms = 512
+
+// patchPrograms caches the programs of the paths patched by the Patcher.
+var patchPrograms = newProgramCache(maxCachedPrograms)
+
+// programCache keeps the most recently used programs, up to size of them.
+type programCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+func newProgramCache(size int) *programCache {
+	return &programCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// compile returns the program of expr, compiling it when it isn't cached.
+// Expressions which don't compile aren't cached.
+func (c *programCache) compile(expr string) (*Program, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[expr]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*Program), nil
+	}
+	c.mu.Unlock()
+
+	prog, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[expr]; ok {
+		// Compiled meanwhile by another goroutine
+		c.order.MoveToFront(elem)
+		return elem.Value.(*Program), nil
+	}
+	c.entries[expr] = c.order.PushFront(prog)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Program).source)
+	}
+	return prog, nil
+}
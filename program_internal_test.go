@@ -0,0 +1,61 @@
+package el
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgramCache(t *testing.T) {
+	cache := newProgramCache(2)
+
+	a, err := cache.compile("a")
+	assert.NoError(t, err)
+	b, err := cache.compile("b")
+	assert.NoError(t, err)
+
+	// A cached path gives back the same program
+	again, err := cache.compile("a")
+	assert.NoError(t, err)
+	assert.True(t, a == again)
+
+	// "b" is the least recently used, it makes room for "c"
+	_, err = cache.compile("c")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cache.order.Len())
+	assert.Len(t, cache.entries, 2)
+	assert.Contains(t, cache.entries, "a")
+	assert.NotContains(t, cache.entries, "b")
+
+	again, err = cache.compile("b")
+	assert.NoError(t, err)
+	assert.False(t, b == again)
+
+	// Expressions which don't compile aren't cached
+	_, err = cache.compile("a[")
+	assert.Error(t, err)
+	assert.NotContains(t, cache.entries, "a[")
+}
+
+func TestPatcherProgramCacheSize(t *testing.T) {
+	patcher := Patcher{}
+	target := &struct{ State map[string]int }{State: map[string]int{}}
+
+	for i := 0; i < maxCachedPrograms+100; i++ {
+		err := patcher.PatchIt(target, Patch{Expression(fmt.Sprintf("state[%d]", i)): i})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, maxCachedPrograms, patchPrograms.order.Len())
+	assert.Len(t, patchPrograms.entries, maxCachedPrograms)
+
+	// The Patcher compiles a path it patched again only once
+	path := fmt.Sprintf("state[%d]", maxCachedPrograms+99)
+	prog, err := patchPrograms.compile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, patcher.PatchIt(target, Patch{Expression(path): 1}))
+	again, err := patchPrograms.compile(path)
+	assert.NoError(t, err)
+	assert.True(t, prog == again)
+	assert.Equal(t, maxCachedPrograms, patchPrograms.order.Len())
+}
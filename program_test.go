@@ -0,0 +1,91 @@
+package el_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	el "github.com/runcom/el"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	prog, err := el.Compile(`Images[?(Content != "")].Content`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `Images[?(Content != "")].Content`, prog.String())
+
+	// One program, many targets
+	for i := 0; i < 3; i++ {
+		u := &User{Images: []*Image{{fmt.Sprint(i)}, {""}}}
+		v, err := prog.Eval(u)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []interface{}{fmt.Sprint(i)}, v.Interface())
+		}
+		values, err := prog.EvalAll(u)
+		if assert.NoError(t, err) && assert.Len(t, values, 1) {
+			assert.NoError(t, values[0].SetValue("set"))
+			assert.Equal(t, "set", u.Images[0].Content)
+		}
+	}
+
+	env := el.NewEnv().With(map[string]interface{}{"i": 1})
+	prog, err = el.Compile(`ImgIDList[$i] * 2`)
+	if assert.NoError(t, err) {
+		v, err := prog.EvalEnv(env, &User{ImgIDList: []int{1, 21}})
+		if assert.NoError(t, err) {
+			assert.Equal(t, 42, v.Interface())
+		}
+		_, err = prog.Eval(&User{ImgIDList: []int{1, 21}})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `ImgIDList[$i] * 2`)
+		}
+	}
+
+	for _, e := range []string{`Images[`, `a b`, `"x`, `x matches "("`} {
+		_, err := el.Compile(e)
+		assert.Error(t, err, e)
+	}
+}
+
+func TestProgramConcurrency(t *testing.T) {
+	prog, err := el.Compile(`upper(Name) + ":" + join(split(Name, ""), "-") matches "^[A-Z0-9]+:u-s-e-r-[0-9]$"`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := &User{Name: fmt.Sprintf("user%d", i)}
+			for j := 0; j < 100; j++ {
+				v, err := prog.Eval(u)
+				if assert.NoError(t, err) {
+					assert.Equal(t, true, v.Interface())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPatcherProgramCache(t *testing.T) {
+	patcher := el.Patcher{}
+
+	// More distinct paths than the cache holds, and the same paths again
+	for round := 0; round < 2; round++ {
+		u := &User{BizState: map[string]int{}}
+		for i := 0; i < 600; i++ {
+			err := patcher.PatchIt(u, el.Patch{el.Expression(fmt.Sprintf("bizState[%d]", i)): i})
+			assert.NoError(t, err)
+		}
+		assert.Len(t, u.BizState, 600)
+		assert.Equal(t, 599, u.BizState["599"])
+	}
+
+	err := patcher.PatchIt(&User{}, el.Patch{"name[": "x"})
+	assert.Error(t, err)
+}
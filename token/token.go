@@ -5,6 +5,7 @@ type Type string
 const (
 	TypeEOF         Type = "end of expression"
 	TypeName        Type = "name"
+	TypeKeyword     Type = "keyword"
 	TypeNumber      Type = "number"
 	TypeString      Type = "string"
 	TypeOperator    Type = "operator"
@@ -12,7 +13,24 @@ const (
 )
 
 type Token struct {
-	Value  interface{}
+	Value  string
 	Type   Type
 	Cursor int
 }
+
+// Test reports whether the token is of the given type and, when values are
+// given, whether its value is one of them.
+func (t Token) Test(typ Type, values ...string) bool {
+	if t.Type != typ {
+		return false
+	}
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if t.Value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenStream struct {
 	// TODO: consider making some of these fields private (?)
 	Tokens   []Token
@@ -7,8 +9,12 @@ type TokenStream struct {
 	Position int
 }
 
+// Next moves the stream to the following token. Once the EOF token is
+// reached the stream stays there and an error is returned.
 func (ts *TokenStream) Next() error {
-	// check that ts.Tokens[ts.Position] exists
+	if ts.Position+1 >= len(ts.Tokens) {
+		return fmt.Errorf("unexpected end of expression")
+	}
 	ts.Position++
 	ts.Current = ts.Tokens[ts.Position]
 	return nil
@@ -22,8 +28,16 @@ func (ts *TokenStream) EOF() bool {
 	return ts.Current.Type == TypeEOF
 }
 
+// NewTokenStream builds a stream over tokens, which is expected to end with
+// an EOF token. One is appended if it is missing.
 func NewTokenStream(tokens []Token) *TokenStream {
-	// TODO: check len(tokens) > 0
+	if len(tokens) == 0 || tokens[len(tokens)-1].Type != TypeEOF {
+		cursor := 1
+		if len(tokens) > 0 {
+			cursor = tokens[len(tokens)-1].Cursor + len(tokens[len(tokens)-1].Value)
+		}
+		tokens = append(tokens, Token{Type: TypeEOF, Cursor: cursor})
+	}
 	return &TokenStream{
 		Tokens:   tokens,
 		Current:  tokens[0],
@@ -0,0 +1,130 @@
+package el
+
+import (
+	"reflect"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// typeInfo holds what paths look up by name on a type: the index paths of
+// its fields, promoted fields included, and the indexes of its methods.
+// Both are keyed by every name a path can use for them, see aliases, so
+// that resolving a part is a map lookup.
+type typeInfo struct {
+	fields  map[string][]int
+	methods map[string]int
+}
+
+// typeInfos caches the typeInfo of the types paths went through, it is
+// shared by all evaluations.
+var typeInfos sync.Map // reflect.Type -> *typeInfo
+
+// infoOf returns the typeInfo of t, building it on first use.
+func infoOf(t reflect.Type) *typeInfo {
+	if info, ok := typeInfos.Load(t); ok {
+		return info.(*typeInfo)
+	}
+	info, _ := typeInfos.LoadOrStore(t, newTypeInfo(t))
+	return info.(*typeInfo)
+}
+
+func newTypeInfo(t reflect.Type) *typeInfo {
+	info := &typeInfo{
+		fields:  map[string][]int{},
+		methods: map[string]int{},
+	}
+
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if method.PkgPath != "" {
+			// Unexported, only interfaces list them
+			continue
+		}
+		for _, alias := range aliases(method.Name) {
+			info.methods[alias] = method.Index
+		}
+	}
+
+	if t.Kind() == reflect.Struct {
+		// FieldByName settles which of the fields of embedded structs are
+		// promoted, and leaves out the ambiguous ones
+		for _, name := range fieldNames(t, nil, map[reflect.Type]bool{}) {
+			field, ok := t.FieldByName(name)
+			if !ok {
+				continue
+			}
+			for _, alias := range aliases(name) {
+				info.fields[alias] = field.Index
+			}
+		}
+	}
+	return info
+}
+
+// fieldNames appends the names of the fields of struct t to names, along
+// with the names of the fields of its embedded structs.
+func fieldNames(t reflect.Type, names []string, seen map[reflect.Type]bool) []string {
+	if seen[t] {
+		return names
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		names = append(names, field.Name)
+		if !field.Anonymous {
+			continue
+		}
+		embedded := field.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if embedded.Kind() == reflect.Struct {
+			names = fieldNames(embedded, names, seen)
+		}
+	}
+	return names
+}
+
+// aliases returns the names a path can use for the field or method name,
+// i.e. the names upperFirst turns into name: "nickName" as well as
+// "NickName".
+func aliases(name string) []string {
+	r, n := utf8.DecodeRuneInString(name)
+	var names []string
+	// The runes whose upper case is r are among its case variants
+	for c := r; ; {
+		if unicode.ToUpper(c) == r {
+			names = append(names, string(c)+name[n:])
+		}
+		if c = unicode.SimpleFold(c); c == r {
+			break
+		}
+	}
+	return names
+}
+
+// fieldByName returns the field of struct v a path names name, as
+// v.FieldByName(upperFirst(name)) does. It returns an error rather than
+// panicking for a field promoted through a nil embedded pointer.
+func fieldByName(v reflect.Value, name string) (reflect.Value, error) {
+	index, ok := infoOf(v.Type()).fields[name]
+	if !ok {
+		return reflect.Value{}, nil
+	}
+	return v.FieldByIndexErr(index)
+}
+
+// methodByName returns the method of v a path names name, as
+// v.MethodByName(upperFirst(name)) does.
+func methodByName(v reflect.Value, name string) reflect.Value {
+	if !v.IsValid() {
+		return reflect.Value{}
+	}
+	index, ok := infoOf(v.Type()).methods[name]
+	if !ok {
+		return reflect.Value{}
+	}
+	return v.Method(index)
+}
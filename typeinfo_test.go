@@ -0,0 +1,177 @@
+package el_test
+
+import (
+	"reflect"
+	"testing"
+
+	el "github.com/runcom/el"
+	"github.com/stretchr/testify/assert"
+)
+
+type Audit struct {
+	CreatedBy string
+	UpdatedBy string
+}
+
+type Person struct {
+	Name string
+}
+
+func (p Person) Initial() string {
+	return p.Name[:1]
+}
+
+type Member struct {
+	Person
+	*Audit
+	Profile *Member
+	Karma   int
+}
+
+type Discussion struct {
+	Title    string
+	Comments map[string]*Reaction
+}
+
+type Reaction struct {
+	Content string
+	Author  *Member
+}
+
+func newDiscussion() *Discussion {
+	author := &Member{Person: Person{Name: "ann"}, Audit: &Audit{CreatedBy: "bob"}}
+	author.Profile = &Member{Person: Person{Name: "Ann Lee"}, Karma: 42}
+	return &Discussion{
+		Title: "Fields",
+		Comments: map[string]*Reaction{
+			"3": {Content: "first", Author: author},
+		},
+	}
+}
+
+func TestTypeInfo(t *testing.T) {
+	d := newDiscussion()
+
+	cases := []struct {
+		exp      string
+		expected interface{}
+	}{
+		// Promoted fields and methods of embedded structs
+		{`Comments["3"].Author.Name`, "ann"},
+		{`Comments["3"].Author.name`, "ann"},
+		{`Comments["3"].Author.Person.Name`, "ann"},
+		{`Comments["3"].Author.CreatedBy`, "bob"},
+		{`Comments["3"].Author.initial()`, "a"},
+		{`Comments["3"].Author.Profile.Name`, "Ann Lee"},
+		{`Comments["3"].Author.Profile.karma`, 42},
+		{`Comments["3"].Author.Profile.Audit`, (*Audit)(nil)},
+		{`Comments["3"].Author.Unknown`, nil},
+		{`Comments["3"].Author.Profile?.CreatedBy`, nil},
+		{`Comments["3"].Author.Profile?.CreatedBy ?? "nobody"`, "nobody"},
+		{`Comments[*].Author.Name`, []interface{}{"ann"}},
+		{`Comments[Content="first"].Author.Karma`, 0},
+	}
+	for _, c := range cases {
+		exp := el.Expression(c.exp)
+		v, err := exp.Execute(d)
+		if assert.NoError(t, err, c.exp) {
+			assert.Equal(t, c.expected, v.Interface(), c.exp)
+		}
+	}
+
+	// Fields promoted through a nil embedded pointer are like fields of a
+	// nil value
+	for _, e := range []string{
+		`Comments["3"].Author.Profile.CreatedBy`,
+		`Comments["3"].Author.Profile.CreatedBy ?? "nobody"`,
+	} {
+		exp := el.Expression(e)
+		_, err := exp.Execute(d)
+		if assert.Error(t, err, e) {
+			assert.Contains(t, err.Error(), "Can't access 'CreatedBy' on a nil value", e)
+		}
+	}
+
+	// Promoted fields are set in their embedded struct
+	patcher := el.Patcher{}
+	assert.NoError(t, patcher.PatchIt(d, el.Patch{`comments["3"].author.updatedBy`: "cid"}))
+	assert.Equal(t, "cid", d.Comments["3"].Author.Audit.UpdatedBy)
+
+	// There's nothing to set through a nil embedded pointer
+	assert.Error(t, patcher.PatchIt(d, el.Patch{`comments["3"].author.profile.updatedBy`: "cid"}))
+
+	// The checker finds the same fields and methods
+	prog, err := el.CompileFor(reflect.TypeOf(d), `Comments["3"].author.createdBy + Comments["3"].Author.Initial()`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, reflect.TypeOf(""), prog.Type())
+	}
+	_, err = el.CompileFor(reflect.TypeOf(d), `Comments["3"].Author.Unknown`)
+	assert.Error(t, err)
+}
+
+func BenchmarkDeepPath(b *testing.B) {
+	d := newDiscussion()
+	prog, err := el.Compile(`Comments["3"].Author.Profile.Name`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPromotedField(b *testing.B) {
+	d := newDiscussion()
+	prog, err := el.Compile(`Comments["3"].author.createdBy`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMethodCall(b *testing.B) {
+	d := newDiscussion()
+	prog, err := el.Compile(`Comments["3"].Author.Profile.Initial()`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	d := newDiscussion()
+	for i := 0; i < 100; i++ {
+		d.Comments[string(rune('a'+i%26))+string(rune('a'+i/26))] = d.Comments["3"]
+	}
+	prog, err := el.Compile(`Comments[?(Author.Profile.Karma > 10)].Author.Name`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
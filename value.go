@@ -14,6 +14,7 @@ var NumberType = reflect.TypeOf(json.Number(""))
 type Value struct {
 	val       reflect.Value
 	keySetter *KeySetter
+	path      string
 }
 
 type KeySetter struct {
@@ -35,10 +36,21 @@ func AsValueWithSetter(i interface{}, keySetter *KeySetter) *Value {
 }
 
 func (v *Value) getResolvedValue() reflect.Value {
-	if v.val.IsValid() && v.val.Kind() == reflect.Ptr {
-		return v.val.Elem()
+	rv := v.val
+	if rv.IsValid() && rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
 	}
-	return v.val
+	if rv.IsValid() && rv.Kind() == reflect.Ptr {
+		return rv.Elem()
+	}
+	return rv
+}
+
+// Path returns the concrete path the value was reached by, e.g.
+// Comments["3"].NickName for a value found by Comments[*].NickName. It is
+// empty for values which weren't reached through a path.
+func (v *Value) Path() string {
+	return v.path
 }
 
 func (v *Value) IsKeySetter() bool {
@@ -219,24 +231,21 @@ func (v *Value) Contains(other *Value) bool {
 		fieldValue := v.getResolvedValue().FieldByName(other.String())
 		return fieldValue.IsValid()
 	case reflect.Map:
-		var mapValue reflect.Value
-		switch other.Interface().(type) {
-		case int:
-			mapValue = v.getResolvedValue().MapIndex(other.getResolvedValue())
-		case string:
-			mapValue = v.getResolvedValue().MapIndex(other.getResolvedValue())
-		default:
+		key, ok := mapKey(v.getResolvedValue().Type().Key(), other)
+		if !ok {
 			return false
 		}
-
-		return mapValue.IsValid()
+		return v.getResolvedValue().MapIndex(key).IsValid()
 	case reflect.String:
 		return strings.Contains(v.getResolvedValue().String(), other.String())
 
 	case reflect.Slice, reflect.Array:
 		for i := 0; i < v.getResolvedValue().Len(); i++ {
-			item := v.getResolvedValue().Index(i)
-			if other.Interface() == item.Interface() {
+			item := &Value{val: v.getResolvedValue().Index(i)}
+			if item.val.Kind() == reflect.Interface {
+				item.val = item.val.Elem()
+			}
+			if valuesEqual(item, other) {
 				return true
 			}
 		}
@@ -247,6 +256,30 @@ func (v *Value) Contains(other *Value) bool {
 	}
 }
 
+// mapKey converts key to a value usable as a key of type keyType. Integers
+// are accepted for string keys, as numbers index maps by their decimal form.
+func mapKey(keyType reflect.Type, key *Value) (reflect.Value, bool) {
+	if key.IsNil() {
+		return reflect.Value{}, false
+	}
+	kv := key.getResolvedValue()
+	switch {
+	case kv.Type().AssignableTo(keyType):
+		return kv, true
+	case keyType.Kind() == reflect.String && key.IsInteger():
+		return reflect.ValueOf(key.String()).Convert(keyType), true
+	case key.IsNumber() && kv.Type().ConvertibleTo(keyType) && keyType.Kind() != reflect.String:
+		converted := kv.Convert(keyType)
+		if !valuesEqual(&Value{val: converted}, key) {
+			return reflect.Value{}, false
+		}
+		return converted, true
+	case kv.Type().ConvertibleTo(keyType) && kv.Kind() == keyType.Kind():
+		return kv.Convert(keyType), true
+	}
+	return reflect.Value{}, false
+}
+
 func (v *Value) CanSlice() bool {
 	switch v.getResolvedValue().Kind() {
 	case reflect.Array, reflect.Slice, reflect.String:
@@ -327,7 +360,6 @@ func (v *Value) SetNumber(nv json.Number) error {
 	default:
 		return fmt.Errorf("Can not use use value %v to patch %s type", resolvedValue, resolvedValue.Kind())
 	}
-	return nil
 }
 
 func (v *Value) ToRealNumber(nv json.Number, valueType reflect.Type) interface{} {
@@ -339,7 +371,7 @@ func (v *Value) ToRealNumber(nv json.Number, valueType reflect.Type) interface{}
 		}
 		switch k := valueType.Kind(); k {
 		default:
-			panic(&reflect.ValueError{"Transform to int failure, err: %v", valueType.Kind()})
+			panic(&reflect.ValueError{Method: "Transform to int failure, err: %v", Kind: valueType.Kind()})
 		case reflect.Int:
 			return int(n)
 		case reflect.Int8:
@@ -351,7 +383,6 @@ func (v *Value) ToRealNumber(nv json.Number, valueType reflect.Type) interface{}
 		case reflect.Int64:
 			return n
 		}
-		return n
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		n, err := strconv.ParseUint(string(nv), 10, 64)
@@ -360,7 +391,7 @@ func (v *Value) ToRealNumber(nv json.Number, valueType reflect.Type) interface{}
 		}
 		switch k := valueType.Kind(); k {
 		default:
-			panic(&reflect.ValueError{"Transform to uint failure, err: %v", valueType.Kind()})
+			panic(&reflect.ValueError{Method: "Transform to uint failure, err: %v", Kind: valueType.Kind()})
 		case reflect.Uint:
 			return uint(n)
 		case reflect.Uint8:
@@ -374,7 +405,6 @@ func (v *Value) ToRealNumber(nv json.Number, valueType reflect.Type) interface{}
 		case reflect.Uintptr:
 			return uintptr(n)
 		}
-		return n
 
 	case reflect.Float32, reflect.Float64:
 		n, err := strconv.ParseFloat(string(nv), valueType.Bits())
@@ -383,25 +413,33 @@ func (v *Value) ToRealNumber(nv json.Number, valueType reflect.Type) interface{}
 		}
 		switch k := valueType.Kind(); k {
 		default:
-			panic(&reflect.ValueError{"Transform to float failure, err: %v", valueType.Kind()})
+			panic(&reflect.ValueError{Method: "Transform to float failure, err: %v", Kind: valueType.Kind()})
 		case reflect.Float32:
-			return int32(n)
+			return float32(n)
 		case reflect.Float64:
 			return n
 		}
-		return n
 
 	default:
 		return fmt.Errorf("Can not use use value %v to patch %s type", valueType, valueType.Kind())
 	}
-	return nil
 }
 
+// SetValue assigns rightValue to the location v was resolved from. A nil
+// rightValue clears pointer, slice, map and interface locations and
+// deletes the key of a map entry.
 func (v *Value) SetValue(rightValue interface{}) error {
 
-	rvType := reflect.TypeOf(rightValue)
+	// Values coming out of an expression (e.g. a literal) can be used as is
+	if value, ok := rightValue.(*Value); ok {
+		rightValue = value.Interface()
+	}
 
-	resolvedValue := v.getResolvedValue()
+	if rightValue == nil {
+		return v.setNil()
+	}
+
+	rvType := reflect.TypeOf(rightValue)
 
 	if rvType == NumberType && !v.IsKeySetter() {
 		nv := rightValue.(json.Number)
@@ -413,28 +451,156 @@ func (v *Value) SetValue(rightValue interface{}) error {
 		target := setter.prev.getResolvedValue()
 		switch target.Kind() {
 		case reflect.Map:
-			if rvType == NumberType {
-				nv := rightValue.(json.Number)
-				rightValue = v.ToRealNumber(nv, target.Type().Elem())
+			nv, err := convertTo(reflect.ValueOf(rightValue), target.Type().Elem())
+			if err != nil {
+				return err
 			}
-			target.SetMapIndex(setter.key, reflect.ValueOf(rightValue))
+			target.SetMapIndex(setter.key, nv)
 			return nil
 		case reflect.Slice:
-			if rvType == NumberType {
-				nv := rightValue.(json.Number)
-				rightValue = v.ToRealNumber(nv, target.Type().Elem())
+			nv, err := convertTo(reflect.ValueOf(rightValue), target.Type().Elem())
+			if err != nil {
+				return err
 			}
-			target.Index(int(setter.key.Int())).Set(reflect.ValueOf(rightValue))
+			target.Index(int(setter.key.Int())).Set(nv)
 			return nil
 		}
 	}
 
-	if rvType != resolvedValue.Type() {
-		return fmt.Errorf("Can not use use value %v to patch %s type", rvType, resolvedValue.Type())
+	dest := v.val
+	if !dest.IsValid() {
+		return fmt.Errorf("Can not use use value %v to patch an invalid value", rvType)
+	}
+	if dest.Kind() == reflect.Ptr && !rvType.AssignableTo(dest.Type()) {
+		// Set what the pointer points to, allocating it when needed
+		if dest.IsNil() {
+			if !dest.CanSet() {
+				return fmt.Errorf("Var %#v is not settable", v.val)
+			}
+			dest.Set(reflect.New(dest.Type().Elem()))
+		}
+		dest = dest.Elem()
+	}
+	nv, err := convertTo(reflect.ValueOf(rightValue), dest.Type())
+	if err != nil {
+		return err
 	}
-	if !resolvedValue.CanSet() {
+	if !dest.CanSet() {
 		return fmt.Errorf("Var %#v is not settable", v.val)
 	}
-	resolvedValue.Set(reflect.ValueOf(rightValue))
+	dest.Set(nv)
 	return nil
 }
+
+func (v *Value) setNil() error {
+	if v.IsKeySetter() {
+		target := v.keySetter.prev.getResolvedValue()
+		if target.Kind() == reflect.Map {
+			// The zero reflect.Value deletes the key
+			target.SetMapIndex(v.keySetter.key, reflect.Value{})
+			return nil
+		}
+	}
+	switch v.val.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		if !v.val.CanSet() {
+			return fmt.Errorf("Var %#v is not settable", v.val)
+		}
+		v.val.Set(reflect.Zero(v.val.Type()))
+		return nil
+	case reflect.Invalid:
+		return fmt.Errorf("Can not set nil to an invalid value")
+	default:
+		return fmt.Errorf("Can not set nil to %s type", v.val.Type())
+	}
+}
+
+// convertTo converts rv to typ. Slices, arrays and maps are converted
+// element by element, so that decoded JSON ([]interface{},
+// map[string]interface{}) and expression literals can be assigned to
+// concretely typed fields. Numbers are converted only when no precision is
+// lost.
+func convertTo(rv reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	if !rv.IsValid() {
+		return reflect.Value{}, fmt.Errorf("Can not use use value nil to patch %s type", typ)
+	}
+	if rv.Kind() == reflect.Interface {
+		return convertTo(rv.Elem(), typ)
+	}
+	if rv.Type().AssignableTo(typ) {
+		return rv, nil
+	}
+	if rv.Type() == NumberType {
+		n := (&Value{}).ToRealNumber(rv.Interface().(json.Number), typ)
+		if err, ok := n.(error); ok {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(n).Convert(typ), nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			break
+		}
+		var out reflect.Value
+		if typ.Kind() == reflect.Slice {
+			out = reflect.MakeSlice(typ, rv.Len(), rv.Len())
+		} else {
+			if rv.Len() != typ.Len() {
+				return reflect.Value{}, fmt.Errorf("Can not use %d values to patch %s type", rv.Len(), typ)
+			}
+			out = reflect.New(typ).Elem()
+		}
+		for i := 0; i < rv.Len(); i++ {
+			item, err := convertTo(rv.Index(i), typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.Index(i).Set(item)
+		}
+		return out, nil
+	case reflect.Map:
+		if rv.Kind() != reflect.Map {
+			break
+		}
+		out := reflect.MakeMapWithSize(typ, rv.Len())
+		for _, k := range rv.MapKeys() {
+			key, err := convertTo(k, typ.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			item, err := convertTo(rv.MapIndex(k), typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.SetMapIndex(key, item)
+		}
+		return out, nil
+	case reflect.Ptr:
+		elem, err := convertTo(rv, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		from := &Value{val: rv}
+		if !from.IsNumber() {
+			break
+		}
+		converted := rv.Convert(typ)
+		if !valuesEqual(&Value{val: converted}, from) {
+			return reflect.Value{}, fmt.Errorf("Can not use number %v as %s without loss", rv.Interface(), typ)
+		}
+		return converted, nil
+	default:
+		if rv.Kind() == typ.Kind() && rv.Type().ConvertibleTo(typ) {
+			return rv.Convert(typ), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("Can not use use value %v to patch %s type", rv.Type(), typ)
+}